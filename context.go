@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/eladyarkoni/bpm/manager"
+	"github.com/fatih/color"
+)
+
+// localContextName the context name of the local server
+const localContextName = "local"
+
+// Environment variables of the global options
+const (
+	remoteEnv  = "BPM_REMOTE"
+	tokenEnv   = "BPM_TOKEN"
+	contextEnv = "BPM_CONTEXT"
+)
+
+// ServerContext a remote server that the cli sends the commands to
+type ServerContext struct {
+	// Remote the server url (e.g. https://example.com:9663)
+	Remote string `json:"remote"`
+	// Token the api token of the server
+	Token string `json:"token,omitempty"`
+	// CACert the pem certificate file of the authority that signed the server certificate
+	CACert string `json:"ca_cert,omitempty"`
+}
+
+// ContextsFile the saved server contexts of the cli
+type ContextsFile struct {
+	// Current the name of the context that is used by default, the local server if it is empty
+	Current  string                    `json:"current,omitempty"`
+	Contexts map[string]*ServerContext `json:"contexts"`
+}
+
+// serverContext the remote server of the cli commands, nil for the local server
+var serverContext *ServerContext
+
+// loadContextsFile loads the contexts file of the bpm home directory
+func loadContextsFile() (*ContextsFile, error) {
+	contexts := &ContextsFile{Contexts: make(map[string]*ServerContext)}
+	contextsBytes, err := ioutil.ReadFile(manager.GetPaths().ContextsFile)
+	if os.IsNotExist(err) {
+		return contexts, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(contextsBytes, contexts); err != nil {
+		return nil, fmt.Errorf("invalid contexts file %s: %s", manager.GetPaths().ContextsFile, err)
+	}
+	if contexts.Contexts == nil {
+		contexts.Contexts = make(map[string]*ServerContext)
+	}
+	return contexts, nil
+}
+
+// save saves the contexts file, the file is readable only by the user because it has tokens
+func (contexts *ContextsFile) save() error {
+	contextsFile := manager.GetPaths().ContextsFile
+	if err := os.MkdirAll(filepath.Dir(contextsFile), 0700); err != nil {
+		return err
+	}
+	contextsBytes, _ := json.MarshalIndent(contexts, "", "  ")
+	return ioutil.WriteFile(contextsFile, contextsBytes, 0600)
+}
+
+// validateRemote validates the url of a remote server
+func validateRemote(remote string) error {
+	remoteURL, err := url.Parse(remote)
+	if err != nil || (remoteURL.Scheme != "http" && remoteURL.Scheme != "https") || remoteURL.Host == "" {
+		return fmt.Errorf("invalid remote %s, an http or https url is expected", remote)
+	}
+	return nil
+}
+
+// parseGlobalOptions parses the options before the command and sets the server context
+//
+// --remote (BPM_REMOTE) targets a remote server with --token (BPM_TOKEN) and --ca-cert,
+// otherwise --context (BPM_CONTEXT) or the current context of the contexts file is used.
+// Returns the command and its arguments
+func parseGlobalOptions(args []string) []string {
+	globalFlags := flag.NewFlagSet("bpm", flag.ExitOnError)
+	remote := globalFlags.String("remote", os.Getenv(remoteEnv), "the url of a remote server")
+	token := globalFlags.String("token", os.Getenv(tokenEnv), "the api token of the remote server")
+	caCert := globalFlags.String("ca-cert", "", "the certificate authority file of the remote server")
+	contextName := globalFlags.String("context", os.Getenv(contextEnv), "the name of the server context")
+	globalFlags.Parse(args)
+	if *remote != "" {
+		if err := validateRemote(*remote); err != nil {
+			printErrorAndExit("Error: %s\n", err)
+		}
+		serverContext = &ServerContext{Remote: *remote, Token: *token, CACert: *caCert}
+		return globalFlags.Args()
+	}
+	contexts, err := loadContextsFile()
+	if err != nil {
+		printErrorAndExit("Error: %s\n", err)
+	}
+	name := *contextName
+	if name == "" {
+		name = contexts.Current
+	}
+	if name == "" || name == localContextName {
+		return globalFlags.Args()
+	}
+	selectedContext, ok := contexts.Contexts[name]
+	if !ok {
+		printErrorAndExit("Error: context %s is not found\n", name)
+	}
+	serverContext = selectedContext
+	if *token != "" {
+		serverContext.Token = *token
+	}
+	if *caCert != "" {
+		serverContext.CACert = *caCert
+	}
+	return globalFlags.Args()
+}
+
+// newServerClient gets the http client and the base url of the server context
+//
+// The local server is connected through its unix socket, the host of the url is not used
+func newServerClient() (*http.Client, string, error) {
+	if serverContext == nil {
+		socketPath := loadServerConfig().GetSocketPath()
+		return &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", socketPath)
+				},
+			},
+		}, "http://bpm", nil
+	}
+	transport := &http.Transport{}
+	if serverContext.CACert != "" {
+		caCertBytes, err := ioutil.ReadFile(serverContext.CACert)
+		if err != nil {
+			return nil, "", err
+		}
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caCertBytes) {
+			return nil, "", fmt.Errorf("no certificates are found in %s", serverContext.CACert)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: caCertPool}
+	}
+	return &http.Client{Transport: transport}, strings.TrimSuffix(serverContext.Remote, "/"), nil
+}
+
+// authorizeServerRequest sets the api token of the server context in the request
+func authorizeServerRequest(req *http.Request) {
+	if serverContext != nil && serverContext.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+serverContext.Token)
+	}
+}
+
+// CommandContext lists, adds, uses or removes the server contexts
+//
+// context [list]
+// context add <name> --remote <url> [--token <token>] [--ca-cert <file>]
+// context use <name>
+// context remove <name>
+func CommandContext(args []string) {
+	contextFlags := flag.NewFlagSet("context", flag.ExitOnError)
+	remote := contextFlags.String("remote", "", "the url of the remote server")
+	token := contextFlags.String("token", "", "the api token of the remote server")
+	caCert := contextFlags.String("ca-cert", "", "the certificate authority file of the remote server")
+	args = parseFlags(contextFlags, args)
+	action := "list"
+	if len(args) > 1 {
+		action = args[1]
+	}
+	contexts, err := loadContextsFile()
+	if err != nil {
+		printErrorAndExit("Error: %s\n", err)
+	}
+	if action != "list" && len(args) < 3 {
+		printErrorAndExit("context name is missing")
+	}
+	switch action {
+	case "list":
+		names := []string{localContextName}
+		for name := range contexts.Contexts {
+			names = append(names, name)
+		}
+		sort.Strings(names[1:])
+		for _, name := range names {
+			current := " "
+			if name == contexts.Current || (name == localContextName && contexts.Current == "") {
+				current = "*"
+			}
+			remote := "unix socket"
+			if name != localContextName {
+				remote = contexts.Contexts[name].Remote
+			}
+			fmt.Printf("%s %s\t%s\n", current, color.CyanString(strToColumn(name, 20)), remote)
+		}
+	case "add":
+		name := args[2]
+		if name == localContextName {
+			printErrorAndExit("context name %s is reserved for the local server", localContextName)
+		}
+		if err := validateRemote(*remote); err != nil {
+			printErrorAndExit("Error: %s\n", err)
+		}
+		if *caCert != "" {
+			*caCert, _ = filepath.Abs(*caCert)
+		}
+		contexts.Contexts[name] = &ServerContext{Remote: *remote, Token: *token, CACert: *caCert}
+		if err := contexts.save(); err != nil {
+			printErrorAndExit("Error: %s\n", err)
+		}
+		printSuccess("Context %s is added successfully\n", name)
+	case "use":
+		name := args[2]
+		if _, ok := contexts.Contexts[name]; !ok && name != localContextName {
+			printErrorAndExit("context %s is not found", name)
+		}
+		contexts.Current = name
+		if name == localContextName {
+			contexts.Current = ""
+		}
+		if err := contexts.save(); err != nil {
+			printErrorAndExit("Error: %s\n", err)
+		}
+		printSuccess("Context %s is used\n", name)
+	case "remove":
+		name := args[2]
+		if _, ok := contexts.Contexts[name]; !ok {
+			printErrorAndExit("context %s is not found", name)
+		}
+		delete(contexts.Contexts, name)
+		if contexts.Current == name {
+			contexts.Current = ""
+		}
+		if err := contexts.save(); err != nil {
+			printErrorAndExit("Error: %s\n", err)
+		}
+		printSuccess("Context %s is removed successfully\n", name)
+	default:
+		printErrorAndExit("unknown context action %s, use list, add, use or remove", action)
+	}
+}
@@ -1,18 +1,24 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"sync"
 
 	"github.com/fatih/color"
 
 	"strconv"
+	"strings"
 
 	"encoding/json"
 
@@ -23,29 +29,54 @@ import (
 	"github.com/eladyarkoni/bpm/server"
 )
 
-const defaultServerPort = 9663
-
 const usageString = `
 ----------------------------------------------
 Bulk Process Manager
 ----------------------------------------------
 Usage:
-	bulk-pm [command] arg1,arg2,arg3...
+	bulk-pm [--remote <url>] [--token <token>] [--ca-cert <file>] [--context <name>] [command] arg1,arg2,arg3...
 
 Commands:
-	server                                     starts the main process manager server
-	add    <working_dir>                       Adds a new project to process manager
+	server [--config <file>] [--socket <path>] [--socket-group <group>] [--tcp[=false]] [--address <address>] [--port <port>]
+	       [--tls-cert <file>] [--tls-key <file>] [--db <path>] [--logs-dir <path>] [--read-timeout <ms>] [--write-timeout <ms>]
+	                                           starts the main process manager server
+	add    [working_dir] [--name <name>] [--interpreter <command>] [--interpreter-args <args>] [--script <script>] [-f <project_file>] [-- script_args...]
+	                                           Adds a new project to process manager (node projects need only the working dir)
+	apply  -f <ecosystem_file> [--dry-run] [--yes]
+	                                           Adds, updates, starts and removes projects to match the ecosystem file,
+	                                           the changes are printed before they are applied
 	status                                     Gets the status of all projects
-	start  <project_name> [num_of_processes]   Starts project processes (if num_of_processes is defined or not 0, the project will run in cluster mode)
+	start  <project_name> [num_of_processes] [--env <profile>|--clear-env]
+	                                           Starts project processes (if num_of_processes is defined or not 0, the project will run in cluster mode)
+	                                           The --env profile is saved and used by the later starts, restarts and respawns until --clear-env
 	stop   <project_name>                      Stops all project processes
+	restart <project_name>                     Restarts all project processes with the same number of cluster processes
+	reload <project_name>                      Replaces the cluster mode processes one at a time without downtime
+	scale  <project_name> <num_of_processes>   Changes the number of cluster mode processes of a running project
 	info   <project_name>                      Gets the information of the added project package name
-	log    <project_name>                      Gets 50 last lines of the package log
+	env    <project_name> [get|set|unset] [KEY[=VALUE]...] [--profile <profile>]
+	                                           Gets, sets or unsets the project environment variables
+	set    <project_name> <key> <value>        Sets a project configuration value (e.g. stop_signal SIGINT, kill_timeout 10000)
+	log    <project_name> [--out|--err] [-f]   Gets 50 last lines of the project stdout and stderr logs, merged by time
+	                                           (-f prints the new lines as they are written until it is interrupted)
+	       [--lines N] [--since T] [--until T] [--grep P] [--where C]... [--raw]
+	                                           Filters the lines by time (e.g. 2006-01-02 15:04:05 or 10m ago) and regular expression,
+	                                           and the JSON lines by their fields (e.g. level>=warn or reqId=abc), JSON lines are pretty printed
+	                                           (the lines are parsed when they are read, they are not indexed, so filters read the log files)
+	logs   [project_name...] [--all] [--out|--err]
+	                                           Follows the logs of the projects (or all the projects) with project name prefixes,
+	                                           the log filters are applied to the lines as they are read
+	flush  <project_name>                      Clears the project log file and deletes its rotated files
+	history <project_name> [num_of_runs]       Gets the last runs of the project processes (50 by default)
+	token  [list|create|revoke] [name]         Lists, creates or revokes the api tokens of the server tcp listener
+	context [list|add|use|remove] [name] [--remote <url>] [--token <token>] [--ca-cert <file>]
+	                                           Lists, adds, uses or removes the remote servers that the commands are sent to
 `
 
 func main() {
-	args := os.Args[1:]
+	args := parseGlobalOptions(os.Args[1:])
 	if len(args) == 0 {
-		fmt.Println(usageString)
+		fmt.Print(usageString)
 		return
 	}
 	command := args[0]
@@ -54,16 +85,38 @@ func main() {
 		CommandServer(args)
 	case "add":
 		CommandAdd(args)
+	case "apply":
+		CommandApply(args)
 	case "status":
 		CommandStatus(args)
 	case "start":
 		CommandStart(args)
 	case "stop":
 		CommandStop(args)
+	case "restart":
+		CommandRestart(args)
+	case "reload":
+		CommandReload(args)
+	case "scale":
+		CommandScale(args)
 	case "info":
 		CommandInfo(args)
+	case "set":
+		CommandSet(args)
+	case "env":
+		CommandEnv(args)
 	case "log":
 		CommandLog(args, 50)
+	case "logs":
+		CommandLogs(args)
+	case "flush":
+		CommandFlush(args)
+	case "history":
+		CommandHistory(args)
+	case "token":
+		CommandToken(args)
+	case "context":
+		CommandContext(args)
 	default:
 		color.Cyan(usageString)
 	}
@@ -81,25 +134,199 @@ func printSuccess(format string, args ...interface{}) {
 }
 
 // CommandServer starts the server process
+//
+// The server configuration file is loaded and the flags override its values
 func CommandServer(args []string) {
-	err := server.Start(strconv.Itoa(defaultServerPort))
+	serverFlags := flag.NewFlagSet("server", flag.ExitOnError)
+	configFilePath := serverFlags.String("config", server.GetConfigFilePath(), "server configuration file (yaml or json)")
+	socket := serverFlags.String("socket", "", "the unix socket path that the server listens on")
+	socketGroup := serverFlags.String("socket-group", "", "the group that can connect to the unix socket")
+	tcp := serverFlags.Bool("tcp", false, "listen on the tcp address and port too (--tcp=false disables the configured tcp)")
+	address := serverFlags.String("address", "", "the tcp address that the server listens on")
+	port := serverFlags.Int("port", 0, "the tcp port that the server listens on")
+	tlsCertFile := serverFlags.String("tls-cert", "", "the pem certificate file of the tcp listener")
+	tlsKeyFile := serverFlags.String("tls-key", "", "the pem private key file of the tcp listener")
+	dbPath := serverFlags.String("db", "", "the database directory")
+	logsDir := serverFlags.String("logs-dir", "", "the directory of the project and server logs")
+	readTimeout := serverFlags.Int("read-timeout", 0, "milliseconds to read a request")
+	writeTimeout := serverFlags.Int("write-timeout", 0, "milliseconds to write a response")
+	serverFlags.Parse(args[1:])
+	config, err := server.LoadConfig(*configFilePath)
 	if err != nil {
 		printErrorAndExit("Can't start the server, error: %s\n", err)
 	}
+	overrides := &server.ConfigOverrides{Config: server.Config{
+		Socket:       *socket,
+		SocketGroup:  *socketGroup,
+		Address:      *address,
+		Port:         *port,
+		TLSCertFile:  *tlsCertFile,
+		TLSKeyFile:   *tlsKeyFile,
+		DBPath:       *dbPath,
+		LogsDir:      *logsDir,
+		ReadTimeout:  *readTimeout,
+		WriteTimeout: *writeTimeout,
+	}}
+	// The tcp flag overrides the configuration only if it is set, so it can disable tcp too
+	serverFlags.Visit(func(setFlag *flag.Flag) {
+		if setFlag.Name == "tcp" {
+			overrides.TCP = tcp
+		}
+	})
+	config.Override(overrides)
+	if err := server.Start(config); err != nil {
+		printErrorAndExit("Can't start the server, error: %s\n", err)
+	}
+}
+
+// loadServerConfig loads the server configuration that the cli connects to
+func loadServerConfig() *server.Config {
+	config, err := server.LoadConfig(server.GetConfigFilePath())
+	if err != nil {
+		printErrorAndExit("Error: %s\n", err)
+	}
+	return config
 }
 
 // CommandAdd adds a new project
 func CommandAdd(args []string) {
-	if len(args) < 2 {
+	addFlags := flag.NewFlagSet("add", flag.ExitOnError)
+	projectFilePath := addFlags.String("f", "", "project file path (yaml or json)")
+	name := addFlags.String("name", "", "project name (default: the package name)")
+	interpreter := addFlags.String("interpreter", "", "the command that runs the script (default: node for node projects)")
+	interpreterArgs := addFlags.String("interpreter-args", "", "space separated arguments that are passed to the interpreter")
+	script := addFlags.String("script", "", "the script that is run by the interpreter (default: the package main script)")
+	// The arguments after -- are the script arguments
+	var scriptArgs []string
+	for i, arg := range args {
+		if arg == "--" {
+			scriptArgs = args[i+1:]
+			args = args[:i]
+			break
+		}
+	}
+	args = parseFlags(addFlags, args)
+	if len(args) < 2 && *projectFilePath == "" && *script == "" {
 		printErrorAndExit("project working dir is missing")
 	}
-	respObj, err := ServerRequest("POST", "manager/project", map[string]string{
-		"working_dir": args[1],
-	}, true)
+	project := &node.Project{WorkingDir: "."}
+	if *projectFilePath != "" {
+		var err error
+		if project, err = manager.ParseProjectFile(*projectFilePath); err != nil {
+			printErrorAndExit("Error: %s\n", err)
+		}
+	}
+	if len(args) > 1 {
+		project.WorkingDir = args[1]
+	}
+	project.WorkingDir, _ = filepath.Abs(project.WorkingDir)
+	if *name != "" {
+		project.Name = *name
+	}
+	if *interpreter != "" {
+		project.Interpreter = *interpreter
+	}
+	if *interpreterArgs != "" {
+		project.InterpreterArgs = strings.Fields(*interpreterArgs)
+	}
+	if *script != "" {
+		project.Script = *script
+	}
+	if len(scriptArgs) > 0 {
+		project.Args = scriptArgs
+	}
+	res, err := ServerRequest("POST", "manager/project", project, true)
+	if err != nil {
+		printErrorAndExit("Error: %s\n", err)
+	} else if !res.Success {
+		printErrorAndExit("Error: %s\n", res.Message)
+	}
+	var warnings []string
+	json.Unmarshal(res.Data, &warnings)
+	for _, warning := range warnings {
+		color.Yellow("Warning: %s\n", warning)
+	}
+	printSuccess("%s\n", res.Message)
+}
+
+// CommandApply reconciles the projects with the ecosystem file
+//
+// The planned changes are printed before they are applied, with --dry-run only the planned
+// changes are printed. Removing projects needs a confirmation or --yes
+func CommandApply(args []string) {
+	applyFlags := flag.NewFlagSet("apply", flag.ExitOnError)
+	ecosystemFilePath := applyFlags.String("f", "", "ecosystem file path (yaml or json)")
+	dryRun := applyFlags.Bool("dry-run", false, "print the changes without applying them")
+	yes := applyFlags.Bool("yes", false, "remove the projects that are not declared without a confirmation")
+	applyFlags.Parse(args[1:])
+	if *ecosystemFilePath == "" {
+		printErrorAndExit("ecosystem file is missing")
+	}
+	ecosystem, err := manager.ParseEcosystemFile(*ecosystemFilePath)
+	if err != nil {
+		printErrorAndExit("Error: %s\n", err)
+	}
+	planRes, err := ServerRequest("POST", "manager/apply?dryRun=true", ecosystem, true)
+	if err != nil {
+		printErrorAndExit("Error: %s\n", err)
+	} else if !planRes.Success {
+		printErrorAndExit("Error: %s\n", planRes.Message)
+	}
+	var plannedChanges []manager.ApplyChange
+	json.Unmarshal(planRes.Data, &plannedChanges)
+	printApplyChanges(plannedChanges)
+	if *dryRun {
+		return
+	}
+	hasRemoves := false
+	for _, change := range plannedChanges {
+		hasRemoves = hasRemoves || change.Action == manager.ApplyActionRemove
+	}
+	if hasRemoves && !*yes && !confirm("The projects that are not declared will be stopped and removed, continue?") {
+		printErrorAndExit("Apply is canceled\n")
+	}
+	res, err := ServerRequest("POST", "manager/apply", ecosystem, true)
 	if err != nil {
 		printErrorAndExit("Error: %s\n", err)
 	}
-	printSuccess(respObj.Message)
+	if !res.Success {
+		var changes []manager.ApplyChange
+		if json.Unmarshal(res.Data, &changes); len(changes) > 0 {
+			fmt.Println("Applied changes before the error:")
+			printApplyChanges(changes)
+		}
+		printErrorAndExit("Error: %s\n", res.Message)
+	}
+	printSuccess("%s\n", res.Message)
+}
+
+// confirm asks the user a yes or no question, the answer is no if it is not y or yes
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// printApplyChanges prints the ecosystem changes of the projects
+func printApplyChanges(changes []manager.ApplyChange) {
+	for _, change := range changes {
+		switch change.Action {
+		case manager.ApplyActionAdd:
+			color.Green("+ add %s\n", change.Project)
+		case manager.ApplyActionUpdate:
+			color.Yellow("~ update %s\n", change.Project)
+		case manager.ApplyActionStart:
+			color.Cyan("> start %s\n", change.Project)
+		case manager.ApplyActionRemove:
+			color.Red("- remove %s\n", change.Project)
+		default:
+			fmt.Printf("  %s (unchanged)\n", change.Project)
+		}
+		for _, fieldDiff := range change.Diff {
+			fmt.Printf("      %s\n", fieldDiff)
+		}
+	}
 }
 
 // CommandStatus gets the status of all added projects
@@ -121,26 +348,72 @@ func CommandStatus(args []string) {
 	}
 	longestProjectNameLength += 5
 
-	color.Cyan("%s\t%s\t%s\t%s\n",
+	color.Cyan("%s\t%s\t%s\t%s\t%s\t%s\n",
 		strToColumn("Project name", longestProjectNameLength),
 		strToColumn("Process PID", 12),
 		strToColumn("State", 12),
 		strToColumn("Duration", 8),
+		strToColumn("Restarts", 8),
+		strToColumn("Last Exit", 12),
 	)
 	for projectName, projectState := range projectStatus {
-		runState := color.RedString(strToColumn("Not Running", 12))
 		var durationMinutes int
 		if projectState.IsRunning() {
-			runState = color.GreenString(strToColumn("Running", 12))
 			durationMinutes = int(time.Now().Sub(projectState.StartTime).Minutes())
 		}
-		fmt.Printf("%s\t%s\t%s\t%s\n",
+		lastExit := ""
+		if !projectState.EndTime.IsZero() {
+			lastExit = exitToString(projectState.LastExitCode, projectState.LastExitSignal)
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\n",
 			strToColumn(projectName, longestProjectNameLength),
 			strToColumn(fmt.Sprintf("%d", projectState.PID), 12),
-			runState,
+			statusToColumn(projectState.GetStatus(), 12),
 			strToColumn(fmt.Sprintf("%dm", durationMinutes), 8),
+			strToColumn(fmt.Sprintf("%d", projectState.RestartCount), 8),
+			strToColumn(lastExit, 12),
 		)
+		for _, workerState := range projectState.Workers {
+			workerStatus := manager.StatusRestarting
+			var workerDurationMinutes int
+			if workerState.PID != 0 {
+				workerStatus = manager.StatusOnline
+				workerDurationMinutes = int(time.Now().Sub(workerState.StartTime).Minutes())
+			}
+			workerLastExit := ""
+			if workerState.Restarts > 0 {
+				workerLastExit = exitToString(workerState.LastExitCode, workerState.LastSignal)
+			}
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\t%s\n",
+				strToColumn(fmt.Sprintf("  worker #%d", workerState.ID), longestProjectNameLength),
+				strToColumn(fmt.Sprintf("%d", workerState.PID), 12),
+				statusToColumn(workerStatus, 12),
+				strToColumn(fmt.Sprintf("%dm", workerDurationMinutes), 8),
+				strToColumn(fmt.Sprintf("%d", workerState.Restarts), 8),
+				strToColumn(workerLastExit, 12),
+			)
+		}
+	}
+}
+
+// statusToColumn gets the colored project status in column size
+func statusToColumn(status manager.ProjectStatus, size int) string {
+	column := strToColumn(string(status), size)
+	switch status {
+	case manager.StatusOnline:
+		return color.GreenString(column)
+	case manager.StatusStarting, manager.StatusStopping, manager.StatusRestarting:
+		return color.YellowString(column)
 	}
+	return color.RedString(column)
+}
+
+// exitToString gets the process exit description, the signal name or the exit code
+func exitToString(exitCode int, exitSignal string) string {
+	if exitSignal != "" {
+		return exitSignal
+	}
+	return fmt.Sprintf("code %d", exitCode)
 }
 
 // CommandInfo Gets the project information
@@ -158,36 +431,429 @@ func CommandInfo(args []string) {
 	var projectModel node.Project
 	json.Unmarshal(res.Data, &projectModel)
 
-	fmt.Printf("Package Name:        %s\n", color.CyanString(projectModel.Package.Name))
-	fmt.Printf("Package Description: %s\n", color.CyanString(projectModel.Package.Description))
-	fmt.Printf("Package Version:     %s\n", color.CyanString(projectModel.Package.Version))
+	fmt.Printf("Project Name:        %s\n", color.CyanString(projectModel.Name))
+	if projectModel.IsNodeProject() {
+		fmt.Printf("Package Name:        %s\n", color.CyanString(projectModel.Package.Name))
+		fmt.Printf("Package Description: %s\n", color.CyanString(projectModel.Package.Description))
+		fmt.Printf("Package Version:     %s\n", color.CyanString(projectModel.Package.Version))
+	}
 	fmt.Printf("Working Dir:         %s\n", color.CyanString(projectModel.WorkingDir))
+	if projectModel.GetStartMode() == node.StartModeScript {
+		fmt.Printf("Start Script:        %s\n", color.CyanString(projectModel.Package.GetStartScript()))
+	} else {
+		if projectModel.Interpreter != "" || len(projectModel.InterpreterArgs) > 0 {
+			fmt.Printf("Interpreter:         %s\n", color.CyanString(strings.TrimSpace(projectModel.Interpreter+" "+strings.Join(projectModel.InterpreterArgs, " "))))
+		}
+		fmt.Printf("Script:              %s\n", color.CyanString(strings.TrimSpace(projectModel.GetScript()+" "+strings.Join(projectModel.Args, " "))))
+	}
+	if projectModel.NodePath != "" {
+		fmt.Printf("Node Path:           %s\n", color.CyanString(projectModel.NodePath))
+	} else if projectModel.NodeVersion != "" {
+		fmt.Printf("Node Version:        %s\n", color.CyanString(projectModel.NodeVersion))
+	}
+	if len(projectModel.NodeArgs) > 0 {
+		fmt.Printf("Node Args:           %s\n", color.CyanString(strings.Join(projectModel.NodeArgs, " ")))
+	}
+	if projectModel.NodeOptions != "" {
+		fmt.Printf("Node Options:        %s\n", color.CyanString(projectModel.NodeOptions))
+	}
+	if projectModel.GetStartMode() == node.StartModeScript && len(projectModel.Args) > 0 {
+		fmt.Printf("Script Args:         %s\n", color.CyanString(strings.Join(projectModel.Args, " ")))
+	}
+	if projectModel.StopSignal != "" {
+		fmt.Printf("Stop Signal:         %s\n", color.CyanString(projectModel.StopSignal))
+	}
+	if projectModel.KillTimeout != 0 {
+		fmt.Printf("Kill Timeout:        %s\n", color.CyanString("%dms", projectModel.KillTimeout))
+	}
+}
+
+// CommandSet sets a project configuration value
+//
+// The key can be a dotted path to a nested configuration value and the value is
+// parsed as json if possible, otherwise it is used as a string
+func CommandSet(args []string) {
+	if len(args) < 4 {
+		printErrorAndExit("usage: set <project_name> <key> <value>")
+	}
+	projectName := args[1]
+	keys := strings.Split(args[2], ".")
+	var value interface{}
+	if err := json.Unmarshal([]byte(args[3]), &value); err != nil {
+		value = args[3]
+	}
+	for i := len(keys) - 1; i >= 0; i-- {
+		value = map[string]interface{}{keys[i]: value}
+	}
+	res, err := ServerRequest("PUT", fmt.Sprintf("manager/project/%s", projectName), value, true)
+	if err != nil {
+		printErrorAndExit("Error: %s\n", err)
+	} else if !res.Success {
+		printErrorAndExit("Error: %s\n", res.Message)
+	}
+	printSuccess("%s\n", res.Message)
 }
 
-// CommandLog Gets the project last X lines of the log file
+// CommandLog Gets the project last X lines of the log files
+//
+// The stdout and stderr logs are merged by default, the stderr lines are printed in red
 func CommandLog(args []string, lastLinesLimit int) {
+	logFlags := flag.NewFlagSet("log", flag.ExitOnError)
+	commandFlags := addLogCommandFlags(logFlags)
+	follow := logFlags.Bool("f", false, "print the new lines as they are written")
+	lines := logFlags.Int("lines", lastLinesLimit, "the number of the last lines, 0 for all the lines")
+	args = parseFlags(logFlags, args)
+	if len(args) < 2 {
+		printErrorAndExit("project name is missing")
+	}
+	projectName := args[1]
+	if *follow {
+		err := followProjectLog(projectName, commandFlags.query(*lines), func(line manager.LogLine) {
+			commandFlags.printLine("", line)
+		})
+		if err != nil {
+			printErrorAndExit("Error: %s\n", err)
+		}
+		return
+	}
+	res, err := ServerRequest("GET", fmt.Sprintf("manager/project/%s/log?%s", projectName, commandFlags.query(*lines).Encode()), nil, true)
+	if err != nil {
+		printErrorAndExit("Error: %s\n", err)
+	} else if !res.Success {
+		printErrorAndExit("Server Error: %s\n", res.Message)
+	}
+	var logLines []manager.LogLine
+	json.Unmarshal(res.Data, &logLines)
+	for _, line := range logLines {
+		commandFlags.printLine("", line)
+	}
+}
+
+// CommandLogs follows the logs of several projects, every line is prefixed by its project name
+//
+// logs <project_name>... or logs --all for all the projects
+func CommandLogs(args []string) {
+	logsFlags := flag.NewFlagSet("logs", flag.ExitOnError)
+	all := logsFlags.Bool("all", false, "follow the logs of all the projects")
+	commandFlags := addLogCommandFlags(logsFlags)
+	lines := logsFlags.Int("lines", 10, "the number of the last lines of every project that are printed first")
+	args = parseFlags(logsFlags, args)
+	projectNames := args[1:]
+	if *all {
+		res, err := ServerRequest("GET", "manager/status", nil, true)
+		if err != nil {
+			printErrorAndExit("Error: %s\n", err)
+		} else if !res.Success {
+			printErrorAndExit("Error: %s\n", res.Message)
+		}
+		var projectStatus map[string]manager.ProjectState
+		json.Unmarshal(res.Data, &projectStatus)
+		projectNames = make([]string, 0)
+		for projectName := range projectStatus {
+			projectNames = append(projectNames, projectName)
+		}
+		sort.Strings(projectNames)
+	}
+	if len(projectNames) == 0 {
+		printErrorAndExit("project name is missing, use --all to follow the logs of all the projects")
+	}
+	longestProjectNameLength := 0
+	for _, projectName := range projectNames {
+		if len(projectName) > longestProjectNameLength {
+			longestProjectNameLength = len(projectName)
+		}
+	}
+	logQuery := commandFlags.query(*lines)
+	var printLock sync.Mutex
+	var followers sync.WaitGroup
+	for i, projectName := range projectNames {
+		prefixColor := logPrefixColors[i%len(logPrefixColors)]
+		prefix := prefixColor.Sprintf("%s |", strToColumn(projectName, longestProjectNameLength))
+		followers.Add(1)
+		go func(projectName string) {
+			defer followers.Done()
+			err := followProjectLog(projectName, logQuery, func(line manager.LogLine) {
+				printLock.Lock()
+				defer printLock.Unlock()
+				commandFlags.printLine(prefix, line)
+			})
+			if err != nil {
+				printLock.Lock()
+				color.Red("%s Error: %s\n", prefix, err)
+				printLock.Unlock()
+			}
+		}(projectName)
+	}
+	followers.Wait()
+}
+
+// logPrefixColors the colors of the project name prefixes of the logs
+var logPrefixColors = []*color.Color{
+	color.New(color.FgCyan),
+	color.New(color.FgMagenta),
+	color.New(color.FgBlue),
+	color.New(color.FgGreen),
+	color.New(color.FgYellow),
+}
+
+// logCommandFlags the stream, filter and output flags of the log commands
+type logCommandFlags struct {
+	outOnly *bool
+	errOnly *bool
+	since   *string
+	until   *string
+	grep    *string
+	where   *stringsFlag
+	// raw prints the structured lines as they are written instead of pretty printing them
+	raw *bool
+}
+
+// addLogCommandFlags defines the stream, filter and output flags of a log command
+func addLogCommandFlags(flagSet *flag.FlagSet) *logCommandFlags {
+	commandFlags := &logCommandFlags{
+		outOnly: flagSet.Bool("out", false, "only the stdout log"),
+		errOnly: flagSet.Bool("err", false, "only the stderr log"),
+		since:   flagSet.String("since", "", "only the lines since a time (e.g. 2006-01-02 15:04:05) or a duration ago (e.g. 10m)"),
+		until:   flagSet.String("until", "", "only the lines until a time or a duration ago"),
+		grep:    flagSet.String("grep", "", "only the lines that match a regular expression"),
+		where:   &stringsFlag{},
+		raw:     flagSet.Bool("raw", false, "print the JSON lines as they are written"),
+	}
+	flagSet.Var(commandFlags.where, "where", "only the JSON lines that match a condition (e.g. level>=warn or reqId=abc), it can be repeated")
+	return commandFlags
+}
+
+// stream gets the log stream of the --out and --err flags, empty for both streams
+func (flags *logCommandFlags) stream() string {
+	if *flags.outOnly && !*flags.errOnly {
+		return manager.LogStreamOut
+	} else if *flags.errOnly && !*flags.outOnly {
+		return manager.LogStreamErr
+	}
+	return ""
+}
+
+// query gets the query params of the log api
+func (flags *logCommandFlags) query(lines int) url.Values {
+	query := url.Values{}
+	query.Set("lines", strconv.Itoa(lines))
+	query.Set("stream", flags.stream())
+	for name, value := range map[string]string{"since": *flags.since, "until": *flags.until, "grep": *flags.grep} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	for _, where := range *flags.where {
+		query.Add("where", where)
+	}
+	return query
+}
+
+// printLine prints the log line with the prefix, stderr lines of both streams are printed in red
+// and the structured lines are pretty printed
+func (flags *logCommandFlags) printLine(prefix string, line manager.LogLine) {
+	text := line.Text
+	if line.Entry != nil && !*flags.raw {
+		text = formatLogEntry(line.Entry)
+	} else if line.Stream == manager.LogStreamErr && flags.stream() == "" {
+		text = color.RedString("%s", text)
+	}
+	if prefix != "" {
+		text = prefix + " " + text
+	}
+	fmt.Println(text)
+}
+
+// logLevelColors the colors of the levels of the pretty printed log lines
+var logLevelColors = map[string]*color.Color{
+	"trace": color.New(color.FgWhite),
+	"debug": color.New(color.FgBlue),
+	"info":  color.New(color.FgGreen),
+	"warn":  color.New(color.FgYellow),
+	"error": color.New(color.FgRed),
+	"fatal": color.New(color.FgRed, color.Bold),
+}
+
+// formatLogEntry formats a structured log line: time, level, message and the other fields sorted by name
+func formatLogEntry(entry *manager.LogEntry) string {
+	parts := make([]string, 0, len(entry.Fields)+3)
+	if entry.Time != nil {
+		parts = append(parts, entry.Time.Local().Format("2006-01-02 15:04:05.000"))
+	}
+	if entry.Level != "" {
+		level := strToColumn(strings.ToUpper(entry.Level), 5)
+		if levelColor, ok := logLevelColors[entry.Level]; ok {
+			level = levelColor.Sprint(level)
+		}
+		parts = append(parts, level)
+	}
+	if entry.Message != "" {
+		parts = append(parts, entry.Message)
+	}
+	fieldNames := make([]string, 0, len(entry.Fields))
+	for name := range entry.Fields {
+		fieldNames = append(fieldNames, name)
+	}
+	sort.Strings(fieldNames)
+	for _, name := range fieldNames {
+		value, isString := entry.Fields[name].(string)
+		if !isString {
+			valueBytes, _ := json.Marshal(entry.Fields[name])
+			value = string(valueBytes)
+		} else if strings.ContainsAny(value, " \t\"=") || value == "" {
+			value = strconv.Quote(value)
+		}
+		parts = append(parts, color.CyanString("%s=", name)+value)
+	}
+	return strings.Join(parts, " ")
+}
+
+// followProjectLog prints the last lines of the project log and the lines that are written after
+// them, until the server closes the stream
+func followProjectLog(projectName string, logQuery url.Values, printLine func(manager.LogLine)) error {
+	resp, err := ServerStream(fmt.Sprintf("manager/project/%s/log/stream?%s", projectName, logQuery.Encode()))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Every event has one log line in its data field, comments and empty lines are skipped
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 2*1024*1024)
+	for scanner.Scan() {
+		eventLine := scanner.Text()
+		if !strings.HasPrefix(eventLine, "data: ") {
+			continue
+		}
+		var line manager.LogLine
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(eventLine, "data: ")), &line); err == nil {
+			printLine(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("log stream of %s is closed by the server", projectName)
+}
+
+// CommandFlush clears the project logs
+func CommandFlush(args []string) {
+	if len(args) < 2 {
+		printErrorAndExit("project name is missing")
+	}
+	projectName := args[1]
+	res, err := ServerRequest("DELETE", fmt.Sprintf("manager/project/%s/log", projectName), nil, true)
+	if err != nil {
+		printErrorAndExit("Error: %s\n", err)
+	} else if !res.Success {
+		printErrorAndExit("Error: %s\n", res.Message)
+	}
+	printSuccess("%s\n", res.Message)
+}
+
+// CommandHistory gets the project last runs
+func CommandHistory(args []string) {
 	if len(args) < 2 {
 		printErrorAndExit("project name is missing")
 	}
 	projectName := args[1]
-	res, err := ServerRequest("GET", fmt.Sprintf("manager/project/%s/log?lines=%d", projectName, lastLinesLimit), nil, true)
+	runsLimit := 50
+	if len(args) == 3 {
+		if limit, argErr := strconv.Atoi(args[2]); argErr == nil {
+			runsLimit = limit
+		}
+	}
+	res, err := ServerRequest("GET", fmt.Sprintf("manager/project/%s/history?limit=%d", projectName, runsLimit), nil, true)
 	if err != nil {
 		printErrorAndExit("Error: %s\n", err)
 	} else if !res.Success {
 		printErrorAndExit("Server Error: %s\n", res.Message)
 	}
-	var logData []string
-	json.Unmarshal(res.Data, &logData)
-	for _, line := range logData {
-		fmt.Println(line)
+	var projectRuns []manager.ProjectRun
+	json.Unmarshal(res.Data, &projectRuns)
+	color.Cyan("%s\t%s\t%s\t%s\t%s\n",
+		strToColumn("Process PID", 12),
+		strToColumn("Start Time", 20),
+		strToColumn("Duration", 12),
+		strToColumn("Exit", 12),
+		strToColumn("Restarted", 9),
+	)
+	for _, projectRun := range projectRuns {
+		restarted := "no"
+		if projectRun.Restarted {
+			restarted = "yes"
+		}
+		fmt.Printf("%s\t%s\t%s\t%s\t%s\n",
+			strToColumn(fmt.Sprintf("%d", projectRun.PID), 12),
+			strToColumn(projectRun.StartTime.Local().Format("2006-01-02 15:04:05"), 20),
+			strToColumn(projectRun.EndTime.Sub(projectRun.StartTime).Round(time.Second).String(), 12),
+			strToColumn(exitToString(projectRun.ExitCode, projectRun.Signal), 12),
+			strToColumn(restarted, 9),
+		)
+	}
+}
+
+// CommandToken lists, creates or revokes the api tokens
+//
+// The token secret is printed once when the token is created
+func CommandToken(args []string) {
+	action := "list"
+	if len(args) > 1 {
+		action = args[1]
+	}
+	if action != "list" && len(args) < 3 {
+		printErrorAndExit("token name is missing")
+	}
+	switch action {
+	case "list":
+		res, err := ServerRequest("GET", "tokens", nil, true)
+		if err != nil {
+			printErrorAndExit("Error: %s\n", err)
+		} else if !res.Success {
+			printErrorAndExit("Error: %s\n", res.Message)
+		}
+		var tokens []manager.APIToken
+		json.Unmarshal(res.Data, &tokens)
+		color.Cyan("%s\t%s\n", strToColumn("Token name", 20), strToColumn("Created", 20))
+		for _, token := range tokens {
+			fmt.Printf("%s\t%s\n", strToColumn(token.Name, 20), strToColumn(token.CreatedAt.Local().Format("2006-01-02 15:04:05"), 20))
+		}
+	case "create":
+		res, err := ServerRequest("POST", "tokens", map[string]string{"name": args[2]}, true)
+		if err != nil {
+			printErrorAndExit("Error: %s\n", err)
+		} else if !res.Success {
+			printErrorAndExit("Error: %s\n", res.Message)
+		}
+		var secret string
+		json.Unmarshal(res.Data, &secret)
+		printSuccess("%s\n", res.Message)
+		fmt.Printf("%s\n", secret)
+		color.Yellow("The token can't be shown again, keep it in a safe place\n")
+	case "revoke":
+		res, err := ServerRequest("DELETE", fmt.Sprintf("tokens/%s", url.PathEscape(args[2])), nil, true)
+		if err != nil {
+			printErrorAndExit("Error: %s\n", err)
+		} else if !res.Success {
+			printErrorAndExit("Error: %s\n", res.Message)
+		}
+		printSuccess("%s\n", res.Message)
+	default:
+		printErrorAndExit("unknown token action %s, use list, create or revoke", action)
 	}
 }
 
 // CommandStart Starts the project processes
 func CommandStart(args []string) {
+	startFlags := flag.NewFlagSet("start", flag.ExitOnError)
+	envProfile := startFlags.String("env", "", "environment profile to start the project with, it is used until another profile is selected")
+	clearEnvProfile := startFlags.Bool("clear-env", false, "start the project without the selected environment profile")
+	args = parseFlags(startFlags, args)
 	if len(args) < 2 {
 		printErrorAndExit("project name is missing")
 	}
+	if *envProfile != "" && *clearEnvProfile {
+		printErrorAndExit("--env and --clear-env can't be used together")
+	}
 	clusterModeProcesses := 0
 	var argErr error
 	if len(args) == 3 {
@@ -197,7 +863,13 @@ func CommandStart(args []string) {
 		}
 	}
 	projectName := args[1]
-	res, err := ServerRequest("POST", fmt.Sprintf("manager/project/%s/start?clusterProcesses=%d", projectName, clusterModeProcesses), nil, true)
+	startURI := fmt.Sprintf("manager/project/%s/start?clusterProcesses=%d", projectName, clusterModeProcesses)
+	if *envProfile != "" {
+		startURI += "&envProfile=" + url.QueryEscape(*envProfile)
+	} else if *clearEnvProfile {
+		startURI += "&clearEnvProfile=true"
+	}
+	res, err := ServerRequest("POST", startURI, nil, true)
 	if err != nil {
 		printErrorAndExit("Error: %s\n", err)
 	} else if !res.Success {
@@ -206,6 +878,83 @@ func CommandStart(args []string) {
 	printSuccess("%s\n", res.Message)
 }
 
+// CommandEnv gets, sets or unsets the project environment variables
+//
+// env <project_name> [get [KEY...]]
+// env <project_name> set KEY=VALUE...
+// env <project_name> unset KEY...
+func CommandEnv(args []string) {
+	envFlags := flag.NewFlagSet("env", flag.ExitOnError)
+	profile := envFlags.String("profile", "", "environment profile name")
+	args = parseFlags(envFlags, args)
+	if len(args) < 2 {
+		printErrorAndExit("project name is missing")
+	}
+	projectName := args[1]
+	action := "get"
+	if len(args) > 2 {
+		action = args[2]
+	}
+	profileQuery := "?profile=" + url.QueryEscape(*profile)
+	switch action {
+	case "get":
+		res, err := ServerRequest("GET", fmt.Sprintf("manager/project/%s/env%s", projectName, profileQuery), nil, true)
+		if err != nil {
+			printErrorAndExit("Error: %s\n", err)
+		} else if !res.Success {
+			printErrorAndExit("Error: %s\n", res.Message)
+		}
+		var projectEnv map[string]string
+		json.Unmarshal(res.Data, &projectEnv)
+		keys := args[3:]
+		if len(keys) == 0 {
+			for key := range projectEnv {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+		}
+		for _, key := range keys {
+			if value, ok := projectEnv[key]; ok {
+				fmt.Printf("%s=%s\n", color.CyanString(key), value)
+			}
+		}
+	case "set":
+		env := make(map[string]string)
+		for _, pair := range args[3:] {
+			separatorIndex := strings.Index(pair, "=")
+			if separatorIndex <= 0 {
+				printErrorAndExit("invalid variable %s, KEY=VALUE is expected", pair)
+			}
+			env[pair[:separatorIndex]] = pair[separatorIndex+1:]
+		}
+		if len(env) == 0 {
+			printErrorAndExit("usage: env <project_name> set KEY=VALUE...")
+		}
+		res, err := ServerRequest("PUT", fmt.Sprintf("manager/project/%s/env%s", projectName, profileQuery), env, true)
+		if err != nil {
+			printErrorAndExit("Error: %s\n", err)
+		} else if !res.Success {
+			printErrorAndExit("Error: %s\n", res.Message)
+		}
+		printSuccess("%s\n", res.Message)
+	case "unset":
+		if len(args) < 4 {
+			printErrorAndExit("usage: env <project_name> unset KEY...")
+		}
+		for _, key := range args[3:] {
+			res, err := ServerRequest("DELETE", fmt.Sprintf("manager/project/%s/env/%s%s", projectName, url.PathEscape(key), profileQuery), nil, true)
+			if err != nil {
+				printErrorAndExit("Error: %s\n", err)
+			} else if !res.Success {
+				printErrorAndExit("Error: %s\n", res.Message)
+			}
+		}
+		printSuccess("Project environment is updated successfully\n")
+	default:
+		printErrorAndExit("unknown env action %s, use get, set or unset", action)
+	}
+}
+
 // CommandStop stops the project processes
 func CommandStop(args []string) {
 	if len(args) < 2 {
@@ -221,21 +970,76 @@ func CommandStop(args []string) {
 	printSuccess("%s\n", res.Message)
 }
 
+// CommandRestart restarts the project processes
+func CommandRestart(args []string) {
+	if len(args) < 2 {
+		printErrorAndExit("project name is missing")
+	}
+	projectName := args[1]
+	res, err := ServerRequest("POST", fmt.Sprintf("manager/project/%s/restart", projectName), nil, true)
+	if err != nil {
+		printErrorAndExit("Error: %s\n", err)
+	} else if !res.Success {
+		printErrorAndExit("Error: %s\n", res.Message)
+	}
+	printSuccess("%s\n", res.Message)
+}
+
+// CommandReload reloads the project cluster mode processes
+func CommandReload(args []string) {
+	if len(args) < 2 {
+		printErrorAndExit("project name is missing")
+	}
+	projectName := args[1]
+	res, err := ServerRequest("POST", fmt.Sprintf("manager/project/%s/reload", projectName), nil, true)
+	if err != nil {
+		printErrorAndExit("Error: %s\n", err)
+	} else if !res.Success {
+		printErrorAndExit("Error: %s\n", res.Message)
+	}
+	printSuccess("%s\n", res.Message)
+}
+
+// CommandScale changes the number of the project cluster mode processes
+func CommandScale(args []string) {
+	if len(args) < 3 {
+		printErrorAndExit("usage: scale <project_name> <num_of_processes>")
+	}
+	projectName := args[1]
+	clusterModeProcesses, argErr := strconv.Atoi(args[2])
+	if argErr != nil {
+		printErrorAndExit("num_of_processes is not a number")
+	}
+	res, err := ServerRequest("POST", fmt.Sprintf("manager/project/%s/scale?clusterProcesses=%d", projectName, clusterModeProcesses), nil, true)
+	if err != nil {
+		printErrorAndExit("Error: %s\n", err)
+	} else if !res.Success {
+		printErrorAndExit("Error: %s\n", res.Message)
+	}
+	printSuccess("%s\n", res.Message)
+}
+
 // ServerRequest sends request to the server and gets response.
 //
-// if server is not runnin, tries to restart the server
+// if server is not runnin, tries to restart the server.
+// Requests to remote servers are sent with the api token of the server context and
+// remote servers are never started
 func ServerRequest(method string, uri string, body interface{}, restartIfFailed bool) (*server.ResponseObject, error) {
 	bodyBytes, _ := json.Marshal(body)
-	req, requestErr := http.NewRequest(method, fmt.Sprintf("http://127.0.0.1:%d/%s", defaultServerPort, uri), bytes.NewBuffer(bodyBytes))
+	client, baseURL, clientErr := newServerClient()
+	if clientErr != nil {
+		return nil, clientErr
+	}
+	req, requestErr := http.NewRequest(method, fmt.Sprintf("%s/%s", baseURL, uri), bytes.NewBuffer(bodyBytes))
 	if requestErr != nil {
 		fmt.Printf("Server request error: %s\n", requestErr)
 		return nil, requestErr
 	}
 	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
+	authorizeServerRequest(req)
 	resp, clientErr := client.Do(req)
 	if clientErr != nil {
-		if !restartIfFailed {
+		if !restartIfFailed || serverContext != nil {
 			return nil, clientErr
 		}
 		startServerErr := StartServerProcess()
@@ -251,6 +1055,39 @@ func ServerRequest(method string, uri string, body interface{}, restartIfFailed
 	return &serverResponse, nil
 }
 
+// ServerStream sends a request to a streaming endpoint of the server and gets the open response
+//
+// if server is not running, tries to start the server like ServerRequest
+func ServerStream(uri string) (*http.Response, error) {
+	client, baseURL, err := newServerClient()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/%s", baseURL, uri), nil)
+	if err != nil {
+		return nil, err
+	}
+	authorizeServerRequest(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		if serverContext != nil {
+			return nil, err
+		}
+		if startServerErr := StartServerProcess(); startServerErr != nil {
+			return nil, startServerErr
+		}
+		return ServerStream(uri)
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		var serverResponse server.ResponseObject
+		json.Unmarshal(respBody, &serverResponse)
+		return nil, fmt.Errorf("%s", serverResponse.Message)
+	}
+	return resp, nil
+}
+
 // StartServerProcess starts the server in a different process
 func StartServerProcess() error {
 	color.Blue("Starting Bulk Daemon...\n")
@@ -258,6 +1095,19 @@ func StartServerProcess() error {
 	ctx := context.Background()
 	command := exec.CommandContext(ctx, os.Args[0], "server")
 	command.Dir = currentExecDir
+	// The server output is written to the server log file
+	serverConfig := loadServerConfig()
+	serverPaths := serverConfig.GetPaths()
+	if err := serverPaths.CreateDirs(); err != nil {
+		return err
+	}
+	serverLogFile, logErr := os.OpenFile(serverPaths.ServerLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if logErr != nil {
+		return logErr
+	}
+	defer serverLogFile.Close()
+	command.Stdout = serverLogFile
+	command.Stderr = serverLogFile
 	runError := command.Start()
 	if runError != nil {
 		return runError
@@ -266,12 +1116,42 @@ func StartServerProcess() error {
 	for true {
 		serverResp, _ := ServerRequest("GET", "status", nil, false)
 		if serverResp != nil {
-			color.Blue("Bulk Daemon is started with pid: %d, port: %d\n\n", command.Process.Pid, defaultServerPort)
+			color.Blue("Bulk Daemon is started with pid: %d, socket: %s\n\n", command.Process.Pid, serverConfig.GetSocketPath())
 			return nil
 		}
 		time.Sleep(time.Millisecond * 100)
 	}
-	color.Blue("Bulk Daemon is started with pid: %d, port: %d\n\n", command.Process.Pid, defaultServerPort)
+	color.Blue("Bulk Daemon is started with pid: %d, socket: %s\n\n", command.Process.Pid, serverConfig.GetSocketPath())
+	return nil
+}
+
+// parseFlags parses the command flags that can be mixed with the positional arguments
+//
+// Returns the positional arguments
+func parseFlags(flagSet *flag.FlagSet, args []string) []string {
+	positionalArgs := make([]string, 0)
+	for len(args) > 0 {
+		flagSet.Parse(args)
+		args = flagSet.Args()
+		if len(args) > 0 {
+			positionalArgs = append(positionalArgs, args[0])
+			args = args[1:]
+		}
+	}
+	return positionalArgs
+}
+
+// stringsFlag a flag that can be repeated, its values are kept in order
+type stringsFlag []string
+
+// String gets the values of the flag
+func (values *stringsFlag) String() string {
+	return strings.Join(*values, ",")
+}
+
+// Set adds a value of the flag
+func (values *stringsFlag) Set(value string) error {
+	*values = append(*values, value)
 	return nil
 }
 
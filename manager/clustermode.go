@@ -1,53 +1,415 @@
 package manager
 
 import (
+	"bufio"
+	"encoding/json"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/eladyarkoni/bpm/node"
 )
 
 // ClusterModeScript the cluster mode script name
-const ClusterModeScript = "cluster_mode_script.js"
+//
+// The script is CommonJS, the .cjs extension keeps it CommonJS even inside module packages
+const ClusterModeScript = "cluster_mode_script.cjs"
+
+// controlSocketName the control socket name of the cluster master process
+const controlSocketName = "control.sock"
+
+// maxControlSocketPathLength unix socket paths are limited to 104 bytes on some systems (108 on linux)
+const maxControlSocketPathLength = 104
+
+const (
+	defaultListenTimeout = 3000
+	// workersStatusTimeout how long to wait for the cluster master process to report its workers
+	workersStatusTimeout = 500 * time.Millisecond
+	// controlDialTimeout how long to wait for the cluster master control socket connection
+	controlDialTimeout = 2 * time.Second
+)
+
+// clusterModeConfig the configuration that is passed to the cluster mode script
+type clusterModeConfig struct {
+	Script        string   `json:"script"`
+	Module        bool     `json:"module"`
+	ExecArgv      []string `json:"exec_argv"`
+	Args          []string `json:"args"`
+	Processes     int      `json:"processes"`
+	ControlSocket string   `json:"control_socket"`
+	ListenTimeout int      `json:"listen_timeout"`
+	KillTimeout   int      `json:"kill_timeout"`
+}
+
+// clusterCommandResponse the response of the cluster master process to a control command
+type clusterCommandResponse struct {
+	Success bool            `json:"success"`
+	Error   string          `json:"error"`
+	Data    json.RawMessage `json:"data"`
+}
 
 // getClusterModeScript get cluster mode node script
 //
 // Get node script that runs node sub processes in cluster mode
-// scriptPath: the package script path
-// processCount: how many subprocesses will be run under the cluster master process
-func getClusterModeScript(scriptPath string, processCount int) []byte {
+// Every worker is forked into a slot, if a worker dies, a new worker is forked into its slot.
+// The cluster master process listens on the control socket for line delimited json commands:
+// {"command": "reload"}: replaces the workers one at a time, a new worker is forked and the old
+// worker is disconnected only after the new worker is listening. If the new worker exits or doesn't
+// listen within the listen timeout, the new worker is stopped, the old worker is kept and the reload fails
+// {"command": "status"}: gets the state of the workers
+// {"command": "scale", "processes": <n>}: forks or stops workers until n workers are running
+func getClusterModeScript(config *clusterModeConfig) []byte {
 	const clusterModeScriptTemplate = `
 var cluster = require('cluster');
+var config = %s;
 if (cluster.isMaster) {
-	for (var i = 0; i < %d; i++) {
-		cluster.fork();
+	var fs = require('fs');
+	var net = require('net');
+	// The workers run with the node and script arguments of the project
+	(cluster.setupPrimary || cluster.setupMaster).call(cluster, {
+		execArgv: process.execArgv.concat(config.exec_argv || []),
+		args: config.args || []
+	});
+	// Workers that exit before the minimum uptime are forked again after the restart delay
+	var minUptime = 1000;
+	var restartDelay = 1000;
+	var slots = [];
+	var nextSlotID = 1;
+	var reloading = false;
+
+	var forkWorker = function(slot) {
+		var worker = cluster.fork({BPM_WORKER_ID: slot.id});
+		worker.slot = slot;
+		worker.startTime = new Date();
+		slot.worker = worker;
+		return worker;
+	};
+
+	var addSlot = function() {
+		var slot = {id: nextSlotID++, worker: null, restarts: 0, lastExitCode: 0, lastSignal: null};
+		slots.push(slot);
+		forkWorker(slot);
+		return slot;
+	};
+
+	// Calls the callback when the worker is listening, or with an error if the worker exits or the
+	// listen timeout is passed
+	var waitForWorker = function(worker, callback) {
+		var finished = false;
+		var finish = function(err) {
+			if (finished) {
+				return;
+			}
+			finished = true;
+			clearTimeout(timer);
+			worker.removeListener('listening', onListening);
+			worker.removeListener('exit', onExit);
+			callback(err);
+		};
+		var onListening = function() {
+			finish(null);
+		};
+		var onExit = function() {
+			finish(new Error('worker ' + worker.process.pid + ' exited before listening'));
+		};
+		var timer = setTimeout(function() {
+			finish(new Error('worker ' + worker.process.pid + ' is not listening after ' + config.listen_timeout + 'ms'));
+		}, config.listen_timeout);
+		worker.on('listening', onListening);
+		worker.on('exit', onExit);
+	};
+
+	// Disconnects the worker and kills it if it is not exited after the kill timeout
+	var stopWorker = function(worker, callback) {
+		worker.stopping = true;
+		if (worker.isDead()) {
+			return callback();
+		}
+		var timer = setTimeout(function() {
+			worker.process.kill('SIGKILL');
+		}, config.kill_timeout);
+		worker.once('exit', function() {
+			clearTimeout(timer);
+			callback();
+		});
+		worker.disconnect();
+	};
+
+	var reload = function(command, callback) {
+		if (reloading) {
+			return callback(new Error('reload is already in progress'));
+		}
+		reloading = true;
+		var reloadSlots = slots.slice();
+		var next = function(index) {
+			if (index >= reloadSlots.length) {
+				reloading = false;
+				return callback(null, {workers: slots.length});
+			}
+			var slot = reloadSlots[index];
+			var oldWorker = slot.worker;
+			var newWorker = forkWorker(slot);
+			waitForWorker(newWorker, function(err) {
+				if (err) {
+					// Roll back the slot to the old worker and stop the new worker
+					slot.worker = oldWorker;
+					return stopWorker(newWorker, function() {
+						reloading = false;
+						callback(err);
+					});
+				}
+				if (!oldWorker) {
+					return next(index + 1);
+				}
+				stopWorker(oldWorker, function() {
+					next(index + 1);
+				});
+			});
+		};
+		next(0);
+	};
+
+	var scale = function(command, callback) {
+		if (reloading) {
+			return callback(new Error('reload is in progress'));
+		}
+		var processes = parseInt(command.processes, 10);
+		if (!(processes > 0)) {
+			return callback(new Error('invalid number of processes'));
+		}
+		while (slots.length < processes) {
+			addSlot();
+		}
+		var removedWorkers = slots.splice(processes).map(function(slot) {
+			return slot.worker;
+		}).filter(function(worker) {
+			return worker;
+		});
+		var pending = removedWorkers.length;
+		if (pending === 0) {
+			return callback(null, {workers: slots.length});
+		}
+		removedWorkers.forEach(function(worker) {
+			stopWorker(worker, function() {
+				if (--pending === 0) {
+					callback(null, {workers: slots.length});
+				}
+			});
+		});
+	};
+
+	var status = function(command, callback) {
+		callback(null, slots.map(function(slot) {
+			var worker = slot.worker;
+			return {
+				id: slot.id,
+				pid: worker && !worker.isDead() ? worker.process.pid : 0,
+				start_time: worker ? worker.startTime : null,
+				restarts: slot.restarts,
+				last_exit_code: slot.lastExitCode,
+				last_signal: slot.lastSignal
+			};
+		}));
+	};
+
+	var commands = {
+		reload: reload,
+		scale: scale,
+		status: status
+	};
+
+	var handleCommand = function(line, reply) {
+		var command;
+		try {
+			command = JSON.parse(line);
+		} catch (err) {
+			return reply({success: false, error: 'invalid command'});
+		}
+		var handler = commands[command.command];
+		if (!handler) {
+			return reply({success: false, error: 'unknown command ' + command.command});
+		}
+		handler(command, function(err, data) {
+			if (err) {
+				return reply({success: false, error: err.message});
+			}
+			reply({success: true, data: data});
+		});
+	};
+
+	var controlServer = net.createServer(function(connection) {
+		var buffer = '';
+		connection.setEncoding('utf8');
+		connection.on('error', function() {});
+		connection.on('data', function(data) {
+			buffer += data;
+			var index;
+			while ((index = buffer.indexOf('\n')) !== -1) {
+				var line = buffer.slice(0, index);
+				buffer = buffer.slice(index + 1);
+				handleCommand(line, function(response) {
+					connection.write(JSON.stringify(response) + '\n');
+				});
+			}
+		});
+	});
+	try {
+		fs.unlinkSync(config.control_socket);
+	} catch (err) {}
+	controlServer.listen(config.control_socket);
+	// The control server should not keep the master process alive
+	controlServer.unref();
+
+	cluster.on('exit', function(worker, code, signal) {
+		var slot = worker.slot;
+		if (worker.stopping || slot.worker !== worker) {
+			return;
+		}
+		// The worker is crashed, fork a new worker in its slot
+		slot.worker = null;
+		slot.restarts++;
+		slot.lastExitCode = code;
+		slot.lastSignal = signal;
+		var uptime = Date.now() - worker.startTime.getTime();
+		setTimeout(function() {
+			if (slots.indexOf(slot) !== -1 && !slot.worker) {
+				forkWorker(slot);
+			}
+		}, uptime < minUptime ? restartDelay : 0);
+	});
+	for (var i = 0; i < config.processes; i++) {
+		addSlot();
 	}
+} else if (config.module) {
+	// ECMAScript modules can't be required, the script is imported by its file url
+	var url = require('url');
+	import(url.pathToFileURL(config.script).href).catch(function(err) {
+		console.error(err);
+		process.exit(1);
+	});
 } else {
 	// Require main script path
-	require('%s');
+	require(config.script);
 }
 `
-	return []byte(fmt.Sprintf(clusterModeScriptTemplate, processCount, scriptPath))
+	configBytes, _ := json.Marshal(config)
+	return []byte(fmt.Sprintf(clusterModeScriptTemplate, configBytes))
+}
+
+// getControlSocketPath gets the control socket path of the project cluster master process
+func getControlSocketPath(packageName string) string {
+	return filepath.Join(getProjectRuntimeDir(packageName), controlSocketName)
 }
 
-// CreateClusterModeScript creates the cluster mode script inside the project working dir
+// CreateClusterModeScript creates the cluster mode script inside the project runtime dir
 //
 // Cluster mode script can fork node script to multiple processes that run as a child
 // processes inside the node cluster
 //
-// This function created the script inside the project working directory and requires the
-// original script of the project inside.
-func CreateClusterModeScript(project *node.Project, processCount int) error {
-	clusterModeScriptName := filepath.Join(project.WorkingDir, ClusterModeScript)
-	scriptFile, fileErr := os.Create(clusterModeScriptName)
+// This function created the script inside the project runtime directory and requires the
+// node script of the project by its absolute path inside, ECMAScript modules are imported.
+// The cluster workers run with the node and script arguments of the node command.
+//
+// Returns the cluster mode script path
+func CreateClusterModeScript(project *node.Project, nodeCommand *node.NodeCommand, processCount int) (string, error) {
+	if controlSocketPath := getControlSocketPath(project.Name); len(controlSocketPath) >= maxControlSocketPathLength {
+		return "", fmt.Errorf("control socket path %s is too long, use a shorter %s", controlSocketPath, homeEnv)
+	}
+	runtimeDir, dirErr := createProjectRuntimeDir(project.Name)
+	if dirErr != nil {
+		return "", dirErr
+	}
+	clusterModeScriptPath := filepath.Join(runtimeDir, ClusterModeScript)
+	scriptFile, fileErr := os.Create(clusterModeScriptPath)
 	if fileErr != nil {
-		return fileErr
+		return "", fileErr
 	}
 	defer scriptFile.Close()
-	_, writeErr := scriptFile.Write(getClusterModeScript(fmt.Sprintf("./%s", project.Package.GetMainScript()), processCount))
+	config := &clusterModeConfig{
+		Script:        getClusterModeScriptPath(project, nodeCommand.Script),
+		Module:        project.Package.IsModuleScript(nodeCommand.Script),
+		ExecArgv:      nodeCommand.NodeArgs,
+		Args:          nodeCommand.ScriptArgs,
+		Processes:     processCount,
+		ControlSocket: getControlSocketPath(project.Name),
+		ListenTimeout: defaultListenTimeout,
+		KillTimeout:   defaultKillTimeout,
+	}
+	if project.ListenTimeout > 0 {
+		config.ListenTimeout = project.ListenTimeout
+	}
+	if project.KillTimeout > 0 {
+		config.KillTimeout = project.KillTimeout
+	}
+	_, writeErr := scriptFile.Write(getClusterModeScript(config))
 	if writeErr != nil {
-		return writeErr
+		return "", writeErr
+	}
+	return clusterModeScriptPath, nil
+}
+
+// getClusterModeScriptPath gets the absolute path of the project script that the cluster workers run
+func getClusterModeScriptPath(project *node.Project, script string) string {
+	if filepath.IsAbs(script) {
+		return script
+	}
+	scriptPath, _ := filepath.Abs(filepath.Join(project.WorkingDir, script))
+	return scriptPath
+}
+
+// sendClusterCommand sends a control command to the project cluster master process
+//
+// Returns the command response data
+func sendClusterCommand(packageName string, command interface{}, timeout time.Duration) (json.RawMessage, error) {
+	// The control socket may not be created yet if the cluster master process is just started
+	var conn net.Conn
+	var dialErr error
+	dialTimeout := controlDialTimeout
+	if timeout < dialTimeout {
+		dialTimeout = timeout
+	}
+	dialDeadline := time.Now().Add(dialTimeout)
+	for {
+		conn, dialErr = net.DialTimeout("unix", getControlSocketPath(packageName), dialTimeout)
+		if dialErr == nil {
+			break
+		}
+		if time.Now().After(dialDeadline) {
+			return nil, fmt.Errorf("can't connect to the cluster master process: %s", dialErr)
+		}
+		time.Sleep(processGroupPollInterval)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+	commandBytes, _ := json.Marshal(command)
+	if _, writeErr := conn.Write(append(commandBytes, '\n')); writeErr != nil {
+		return nil, writeErr
+	}
+	responseLine, readErr := bufio.NewReader(conn).ReadBytes('\n')
+	if readErr != nil {
+		return nil, fmt.Errorf("can't read the cluster master process response: %s", readErr)
+	}
+	var response clusterCommandResponse
+	if err := json.Unmarshal(responseLine, &response); err != nil {
+		return nil, err
+	}
+	if !response.Success {
+		return nil, fmt.Errorf("%s", response.Error)
+	}
+	return response.Data, nil
+}
+
+// GetProjectWorkers gets the state of the project cluster mode workers
+func GetProjectWorkers(packageName string) ([]WorkerState, error) {
+	workersData, err := sendClusterCommand(packageName, map[string]string{"command": "status"}, workersStatusTimeout)
+	if err != nil {
+		return nil, err
+	}
+	var workers []WorkerState
+	if err := json.Unmarshal(workersData, &workers); err != nil {
+		return nil, err
 	}
-	return nil
+	return workers, nil
 }
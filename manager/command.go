@@ -0,0 +1,132 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/eladyarkoni/bpm/node"
+)
+
+// defaultInterpreter the interpreter of node projects
+const defaultInterpreter = "node"
+
+// getPackageBinDir gets the directory of the package dependencies binaries
+func getPackageBinDir(project *node.Project) string {
+	return filepath.Join(project.WorkingDir, "node_modules", ".bin")
+}
+
+// projectCommand the command that runs the project
+type projectCommand struct {
+	// program the executable that is started
+	program string
+	// args the program arguments of commands that don't run a node script
+	args []string
+	// nodeCommand the node and script arguments of commands that run a node script
+	nodeCommand *node.NodeCommand
+}
+
+// getArgs gets the command arguments, the first argument is the program
+func (command *projectCommand) getArgs() []string {
+	args := []string{command.program}
+	if command.nodeCommand == nil {
+		return append(args, command.args...)
+	}
+	args = append(args, command.nodeCommand.NodeArgs...)
+	args = append(args, command.nodeCommand.Script)
+	return append(args, command.nodeCommand.ScriptArgs...)
+}
+
+// getProjectCommand gets the command arguments that start the project processes
+//
+// In cluster mode, the cluster mode script is created and replaces the node script of the project,
+// the node and script arguments are passed to the cluster workers.
+func getProjectCommand(project *node.Project, nodePath string, clusterProcesses int) ([]string, error) {
+	command, err := resolveProjectCommand(project, nodePath)
+	if err != nil {
+		return nil, err
+	}
+	if clusterProcesses > 0 {
+		if command.nodeCommand == nil {
+			return nil, fmt.Errorf("cluster mode requires a node script")
+		}
+		clusterModeScript, err := CreateClusterModeScript(project, command.nodeCommand, clusterProcesses)
+		if err != nil {
+			return nil, fmt.Errorf("can't create the cluster mode script: %s", err)
+		}
+		command.nodeCommand = &node.NodeCommand{Script: clusterModeScript}
+	}
+	return command.getArgs(), nil
+}
+
+// resolveProjectCommand resolves the command that runs the project
+//
+// In main mode, the interpreter runs the project script (default: the package main script),
+// projects without interpreter run the script as an executable.
+// In start mode, the package start script is parsed into arguments, scripts that need a
+// shell are executed by sh. The project arguments are appended to the start script like npm does.
+//
+// node is replaced by the node path of the project and the node arguments of the project
+// are passed to node before the script.
+func resolveProjectCommand(project *node.Project, nodePath string) (*projectCommand, error) {
+	switch startMode := project.GetStartMode(); startMode {
+	case node.StartModeMain:
+		script := project.GetScript()
+		if script == "" {
+			return nil, fmt.Errorf("project has no script")
+		}
+		interpreter := project.Interpreter
+		if (interpreter == "" && project.IsNodeProject()) || interpreter == defaultInterpreter {
+			interpreter = nodePath
+		}
+		if interpreter == "" {
+			// Scripts without interpreter are executables, executables of the working dir are preferred
+			scriptPath := filepath.Join(project.WorkingDir, script)
+			if _, err := os.Stat(scriptPath); err == nil && !filepath.IsAbs(script) {
+				script = scriptPath
+			}
+			return &projectCommand{program: script, args: project.Args}, nil
+		}
+		if !project.IsNodeProject() && filepath.Base(interpreter) != defaultInterpreter {
+			args := append(append([]string{}, project.InterpreterArgs...), script)
+			return &projectCommand{program: interpreter, args: append(args, project.Args...)}, nil
+		}
+		return &projectCommand{
+			program: interpreter,
+			nodeCommand: &node.NodeCommand{
+				NodeArgs:   append(append([]string{}, project.NodeArgs...), project.InterpreterArgs...),
+				Script:     script,
+				ScriptArgs: project.Args,
+			},
+		}, nil
+	case node.StartModeScript:
+		startScript := project.Package.GetStartScript()
+		if startScript == "" {
+			return nil, fmt.Errorf("project has no start script")
+		}
+		args, ok := node.ParseScript(startScript)
+		if !ok {
+			return &projectCommand{program: "sh", args: append([]string{"-c", startScript + ` "$@"`, "sh"}, project.Args...)}, nil
+		}
+		args = append(args, project.Args...)
+		if args[0] == defaultInterpreter {
+			args[0] = nodePath
+		}
+		// The process is started with the manager PATH, the package binaries are looked up first
+		if !strings.Contains(args[0], "/") {
+			binPath := filepath.Join(getPackageBinDir(project), args[0])
+			if _, err := os.Stat(binPath); err == nil {
+				args[0] = binPath
+			}
+		}
+		nodeCommand, ok := node.ParseNodeCommand(args)
+		if !ok {
+			return &projectCommand{program: args[0], args: args[1:]}, nil
+		}
+		nodeCommand.NodeArgs = append(append([]string{}, project.NodeArgs...), nodeCommand.NodeArgs...)
+		return &projectCommand{program: args[0], nodeCommand: nodeCommand}, nil
+	default:
+		return nil, fmt.Errorf("unknown start mode %s", startMode)
+	}
+}
@@ -0,0 +1,288 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"github.com/eladyarkoni/bpm/node"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Ecosystem apply actions
+const (
+	ApplyActionAdd    = "add"
+	ApplyActionUpdate = "update"
+	ApplyActionStart  = "start"
+	ApplyActionRemove = "remove"
+	ApplyActionNone   = "none"
+)
+
+// EcosystemFile the declaration of all the projects that are managed by bpm
+//
+// Every app contains the project configuration fields (name, working_dir, script, env...),
+// the app name is optional for node projects and defaults to the package name.
+type EcosystemFile struct {
+	Apps []node.Project `json:"apps"`
+}
+
+// ApplyChange a change that reconciles a project with the ecosystem file
+type ApplyChange struct {
+	Project string `json:"project"`
+	Action  string `json:"action"`
+	// Diff the configuration fields that are changed
+	Diff []string `json:"diff,omitempty"`
+	// desiredProject the project as declared in the ecosystem file
+	desiredProject *node.Project
+}
+
+// ParseEcosystemFile parses an ecosystem yaml or json file
+//
+// Relative working directories and log paths are resolved from the ecosystem file directory
+func ParseEcosystemFile(filePath string) (*EcosystemFile, error) {
+	var ecosystem EcosystemFile
+	if err := ReadConfigFile(filePath, &ecosystem); err != nil {
+		return nil, fmt.Errorf("invalid ecosystem file: %s", err)
+	}
+	for i := range ecosystem.Apps {
+		resolveProjectPaths(&ecosystem.Apps[i], filePath)
+	}
+	return &ecosystem, nil
+}
+
+// ParseProjectFile parses a project yaml or json file
+//
+// The project file has the fields of an ecosystem app, relative working directory and log
+// path are resolved from the project file directory (the working directory is the default)
+func ParseProjectFile(filePath string) (*node.Project, error) {
+	var project node.Project
+	if err := ReadConfigFile(filePath, &project); err != nil {
+		return nil, fmt.Errorf("invalid project file: %s", err)
+	}
+	if project.WorkingDir == "" {
+		project.WorkingDir = "."
+	}
+	resolveProjectPaths(&project, filePath)
+	return &project, nil
+}
+
+// ReadConfigFile reads a yaml or json configuration file into the value
+//
+// The yaml fields are decoded by the json field names, unknown fields are rejected
+func ReadConfigFile(filePath string, value interface{}) error {
+	fileBytes, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	// yaml is a superset of json, the yaml document is converted to json to use the project json fields
+	var document interface{}
+	if err := yaml.Unmarshal(fileBytes, &document); err != nil {
+		return err
+	}
+	documentBytes, err := json.Marshal(convertYAMLToJSON(document))
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(documentBytes))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(value)
+}
+
+// resolveProjectPaths resolves the relative paths of the project from the configuration file directory
+func resolveProjectPaths(project *node.Project, filePath string) {
+	fileDir, _ := filepath.Abs(filepath.Dir(filePath))
+	if project.WorkingDir != "" && !filepath.IsAbs(project.WorkingDir) {
+		project.WorkingDir = filepath.Join(fileDir, project.WorkingDir)
+	}
+	if project.LogPath != "" && !filepath.IsAbs(project.LogPath) {
+		project.LogPath = filepath.Join(fileDir, project.LogPath)
+	}
+	if project.ErrorLogPath != "" && !filepath.IsAbs(project.ErrorLogPath) {
+		project.ErrorLogPath = filepath.Join(fileDir, project.ErrorLogPath)
+	}
+}
+
+// convertYAMLToJSON converts the yaml maps (map[interface{}]interface{}) to json objects
+func convertYAMLToJSON(value interface{}) interface{} {
+	switch typedValue := value.(type) {
+	case map[interface{}]interface{}:
+		jsonObject := make(map[string]interface{})
+		for key, mapValue := range typedValue {
+			jsonObject[fmt.Sprintf("%v", key)] = convertYAMLToJSON(mapValue)
+		}
+		return jsonObject
+	case []interface{}:
+		for i, item := range typedValue {
+			typedValue[i] = convertYAMLToJSON(item)
+		}
+	}
+	return value
+}
+
+// PlanEcosystem gets the changes that are needed to reconcile the manager projects with the ecosystem
+//
+// Projects that are not declared in the ecosystem are removed, declared projects are added or
+// updated and started if they are not running.
+func PlanEcosystem(ecosystem *EcosystemFile) ([]ApplyChange, error) {
+	changes := make([]ApplyChange, 0)
+	declaredProjects := make(map[string]bool)
+	declaredChanges := make([]ApplyChange, 0)
+	for i := range ecosystem.Apps {
+		desiredProject, err := getEcosystemProject(&ecosystem.Apps[i])
+		if err != nil {
+			return nil, err
+		}
+		packageName := desiredProject.Name
+		if declaredProjects[packageName] {
+			return nil, fmt.Errorf("project %s is declared more than once", packageName)
+		}
+		declaredProjects[packageName] = true
+		change := ApplyChange{
+			Project:        packageName,
+			Action:         ApplyActionNone,
+			desiredProject: desiredProject,
+		}
+		currentProject, err := GetProject(packageName)
+		if err != nil {
+			change.Action = ApplyActionAdd
+			change.Diff = getProjectDiff(&node.Project{}, desiredProject)
+		} else {
+			change.Diff = getProjectDiff(currentProject, desiredProject)
+			projectState, _ := GetProjectState(packageName)
+			isRunning := projectState != nil && projectState.IsRunning()
+			if len(change.Diff) > 0 {
+				change.Action = ApplyActionUpdate
+				if !isRunning {
+					// The updated project is started by its own change
+					declaredChanges = append(declaredChanges, change)
+					change = ApplyChange{Project: packageName, Action: ApplyActionStart, desiredProject: desiredProject}
+				}
+			} else if !isRunning {
+				change.Action = ApplyActionStart
+			}
+		}
+		declaredChanges = append(declaredChanges, change)
+	}
+	// Projects are removed before the declared projects are applied
+	projectIter := db.NewIterator(util.BytesPrefix([]byte(projectPrefixKey)), nil)
+	for projectIter.Next() {
+		var projectData node.Project
+		json.Unmarshal(projectIter.Value(), &projectData)
+		if !declaredProjects[projectData.Name] {
+			changes = append(changes, ApplyChange{
+				Project: projectData.Name,
+				Action:  ApplyActionRemove,
+			})
+		}
+	}
+	projectIter.Release()
+	return append(changes, declaredChanges...), nil
+}
+
+// ApplyEcosystem reconciles the manager projects with the ecosystem
+//
+// Returns the applied changes, if a change fails the changes that are applied before it are
+// returned with the error
+func ApplyEcosystem(ecosystem *EcosystemFile) ([]ApplyChange, error) {
+	changes, err := PlanEcosystem(ecosystem)
+	if err != nil {
+		return nil, err
+	}
+	for i, change := range changes {
+		var applyErr error
+		switch change.Action {
+		case ApplyActionRemove:
+			// A project that is not stopped is not removed, its processes would not be tracked
+			if projectState, _ := GetProjectState(change.Project); projectState != nil && projectState.IsRunning() {
+				_, applyErr = StopProject(change.Project)
+			}
+			if applyErr == nil {
+				applyErr = RemoveProject(change.Project)
+			}
+		case ApplyActionAdd:
+			if applyErr = SaveProject(change.desiredProject); applyErr == nil {
+				applyErr = StartProject(change.Project, change.desiredProject.ClusterProcesses, nil)
+			}
+		case ApplyActionUpdate:
+			// Running projects are restarted to use the new configuration, stopped projects are
+			// started by their own start change
+			if applyErr = SaveProject(change.desiredProject); applyErr == nil {
+				if projectState, _ := GetProjectState(change.Project); projectState != nil && projectState.IsRunning() {
+					applyErr = RestartProject(change.Project, nil)
+				}
+			}
+		case ApplyActionStart:
+			applyErr = StartProject(change.Project, change.desiredProject.ClusterProcesses, nil)
+		}
+		if applyErr != nil {
+			return changes[:i], fmt.Errorf("%s %s: %s", change.Action, change.Project, applyErr)
+		}
+	}
+	return changes, nil
+}
+
+// getEcosystemProject gets the project model of the ecosystem app
+func getEcosystemProject(app *node.Project) (*node.Project, error) {
+	if app.WorkingDir == "" {
+		return nil, fmt.Errorf("working_dir of app %s is empty", app.Name)
+	}
+	project := *app
+	if err := loadProjectPackage(&project); err != nil {
+		return nil, err
+	}
+	if err := validateProject(&project); err != nil {
+		return nil, fmt.Errorf("%s: %s", project.Name, err)
+	}
+	return &project, nil
+}
+
+// getProjectDiff gets the configuration fields that are different between the projects
+//
+// Every changed field is described as "field: current value -> desired value"
+func getProjectDiff(currentProject *node.Project, desiredProject *node.Project) []string {
+	currentConfig := getProjectConfig(currentProject)
+	desiredConfig := getProjectConfig(desiredProject)
+	fields := make([]string, 0)
+	for field := range currentConfig {
+		fields = append(fields, field)
+	}
+	for field := range desiredConfig {
+		if _, ok := currentConfig[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	diff := make([]string, 0)
+	for _, field := range fields {
+		currentValue, desiredValue := currentConfig[field], desiredConfig[field]
+		if !reflect.DeepEqual(currentValue, desiredValue) {
+			diff = append(diff, fmt.Sprintf("%s: %s -> %s", field, configValueToString(currentValue), configValueToString(desiredValue)))
+		}
+	}
+	return diff
+}
+
+// getProjectConfig gets the project configuration fields without the package information
+func getProjectConfig(project *node.Project) map[string]interface{} {
+	projectConfig := *project
+	projectConfig.Package = node.Package{}
+	projectConfigBytes, _ := json.Marshal(projectConfig)
+	var config map[string]interface{}
+	json.Unmarshal(projectConfigBytes, &config)
+	delete(config, "package")
+	return config
+}
+
+// configValueToString gets the configuration value as a json string
+func configValueToString(value interface{}) string {
+	if value == nil {
+		return "<none>"
+	}
+	valueBytes, _ := json.Marshal(value)
+	return string(valueBytes)
+}
@@ -0,0 +1,175 @@
+package manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/eladyarkoni/bpm/node"
+)
+
+// GetProjectEnv gets the environment variables of the project
+//
+// The env file variables are overridden by the project variables, which are overridden
+// by the environment profile variables.
+// If profile is empty, the environment profile of the project is used
+func GetProjectEnv(packageName string, profile string) (map[string]string, error) {
+	projectData, err := GetProject(packageName)
+	if err != nil {
+		return nil, fmt.Errorf("project is not found")
+	}
+	return resolveProjectEnv(projectData, profile)
+}
+
+// resolveProjectEnv merges the env file, project and profile variables of the project
+func resolveProjectEnv(project *node.Project, profile string) (map[string]string, error) {
+	env := make(map[string]string)
+	envFile := project.EnvFile
+	if envFile == "" {
+		envFile = node.DefaultEnvFile
+	}
+	if !filepath.IsAbs(envFile) {
+		envFile = filepath.Join(project.WorkingDir, envFile)
+	}
+	fileEnv, err := node.ReadEnvFile(envFile)
+	// The default env file is optional
+	if err != nil && !(os.IsNotExist(err) && project.EnvFile == "") {
+		return nil, err
+	}
+	for key, value := range fileEnv {
+		env[key] = value
+	}
+	for key, value := range project.Env {
+		env[key] = value
+	}
+	if profile == "" {
+		profile = project.EnvProfile
+	}
+	if profile != "" {
+		profileEnv, ok := project.EnvProfiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("environment profile %s is not found", profile)
+		}
+		for key, value := range profileEnv {
+			env[key] = value
+		}
+	}
+	return env, nil
+}
+
+// getProjectProcessEnv gets the environment of the project processes
+//
+// The project processes inherit the server environment, the project variables override it
+func getProjectProcessEnv(project *node.Project, nodePath string) ([]string, error) {
+	projectEnv, err := resolveProjectEnv(project, "")
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(projectEnv))
+	for key := range projectEnv {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	env := os.Environ()
+	for _, key := range keys {
+		env = append(env, fmt.Sprintf("%s=%s", key, projectEnv[key]))
+	}
+	if project.NodeOptions != "" {
+		nodeOptions, ok := projectEnv["NODE_OPTIONS"]
+		if !ok {
+			nodeOptions = os.Getenv("NODE_OPTIONS")
+		}
+		env = append(env, "NODE_OPTIONS="+strings.TrimSpace(nodeOptions+" "+project.NodeOptions))
+	}
+	// The node binary and the package dependencies binaries are added to the PATH like npm does
+	pathDirs := make([]string, 0)
+	if project.GetStartMode() == node.StartModeScript {
+		pathDirs = append(pathDirs, getPackageBinDir(project))
+	}
+	if filepath.IsAbs(nodePath) {
+		pathDirs = append(pathDirs, filepath.Dir(nodePath))
+	}
+	if len(pathDirs) > 0 {
+		path, ok := projectEnv["PATH"]
+		if !ok {
+			path = os.Getenv("PATH")
+		}
+		pathDirs = append(pathDirs, path)
+		env = append(env, "PATH="+strings.Join(pathDirs, string(os.PathListSeparator)))
+	}
+	return env, nil
+}
+
+// SetProjectEnv sets environment variables of the project
+//
+// If profile is not empty, the variables are set in the environment profile
+func SetProjectEnv(packageName string, profile string, env map[string]string) error {
+	projectData, err := GetProject(packageName)
+	if err != nil {
+		return fmt.Errorf("project is not found")
+	}
+	for key := range env {
+		if key == "" || strings.ContainsAny(key, "= \t\n") {
+			return fmt.Errorf("invalid environment variable name %q", key)
+		}
+	}
+	targetEnv := projectData.Env
+	if profile == "" {
+		if targetEnv == nil {
+			targetEnv = make(map[string]string)
+			projectData.Env = targetEnv
+		}
+	} else {
+		if projectData.EnvProfiles == nil {
+			projectData.EnvProfiles = make(map[string]map[string]string)
+		}
+		targetEnv = projectData.EnvProfiles[profile]
+		if targetEnv == nil {
+			targetEnv = make(map[string]string)
+			projectData.EnvProfiles[profile] = targetEnv
+		}
+	}
+	for key, value := range env {
+		targetEnv[key] = value
+	}
+	return SaveProject(projectData)
+}
+
+// UnsetProjectEnv removes environment variables of the project
+//
+// If profile is not empty, the variables are removed from the environment profile
+func UnsetProjectEnv(packageName string, profile string, keys []string) error {
+	projectData, err := GetProject(packageName)
+	if err != nil {
+		return fmt.Errorf("project is not found")
+	}
+	targetEnv := projectData.Env
+	if profile != "" {
+		profileEnv, ok := projectData.EnvProfiles[profile]
+		if !ok {
+			return fmt.Errorf("environment profile %s is not found", profile)
+		}
+		targetEnv = profileEnv
+	}
+	for _, key := range keys {
+		delete(targetEnv, key)
+	}
+	return SaveProject(projectData)
+}
+
+// SetProjectEnvProfile selects the environment profile that is used when the project is started
+//
+// The profile is used by the later starts, restarts and respawns, an empty profile clears it
+func SetProjectEnvProfile(packageName string, profile string) error {
+	projectData, err := GetProject(packageName)
+	if err != nil {
+		return fmt.Errorf("project is not found")
+	}
+	if _, ok := projectData.EnvProfiles[profile]; !ok && profile != "" {
+		return fmt.Errorf("environment profile %s is not found", profile)
+	}
+	projectData.EnvProfile = profile
+	return SaveProject(projectData)
+}
@@ -0,0 +1,85 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	historyPrefixKey = "history-"
+	// maxProjectRuns the maximum number of runs that are kept in the history of a project
+	maxProjectRuns = 1000
+)
+
+// ProjectRun a single run of the project process
+type ProjectRun struct {
+	PID       int       `json:"pid"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+	ExitCode  int       `json:"exit_code"`
+	Signal    string    `json:"signal,omitempty"`
+	// Restarted is true if the process is crashed and bpm restarted it
+	Restarted bool `json:"restarted"`
+}
+
+// getHistoryPrefixKey gets the db key prefix of the project runs
+func getHistoryPrefixKey(packageName string) string {
+	return historyPrefixKey + packageName + ":"
+}
+
+// saveProjectRun saves the project run in the project history
+//
+// Runs are saved by their start time, the oldest runs are deleted when the history is full
+func saveProjectRun(packageName string, run *ProjectRun) error {
+	runBytes, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	runKey := fmt.Sprintf("%s%020d", getHistoryPrefixKey(packageName), run.StartTime.UnixNano())
+	if err := db.Put([]byte(runKey), runBytes, nil); err != nil {
+		return err
+	}
+	historyIter := db.NewIterator(util.BytesPrefix([]byte(getHistoryPrefixKey(packageName))), nil)
+	defer historyIter.Release()
+	runsCount := 0
+	for historyIter.Last(); historyIter.Valid(); historyIter.Prev() {
+		runsCount++
+		if runsCount > maxProjectRuns {
+			db.Delete(historyIter.Key(), nil)
+		}
+	}
+	return historyIter.Error()
+}
+
+// GetProjectHistory gets the last runs of the project, ordered from the oldest run
+//
+// If limit is 0, all the runs in the history are returned
+func GetProjectHistory(packageName string, limit int) ([]ProjectRun, error) {
+	if _, err := GetProject(packageName); err != nil {
+		return nil, fmt.Errorf("project is not found")
+	}
+	runs := make([]ProjectRun, 0)
+	historyIter := db.NewIterator(util.BytesPrefix([]byte(getHistoryPrefixKey(packageName))), nil)
+	defer historyIter.Release()
+	for historyIter.Last(); historyIter.Valid(); historyIter.Prev() {
+		if limit > 0 && len(runs) == limit {
+			break
+		}
+		var run ProjectRun
+		json.Unmarshal(historyIter.Value(), &run)
+		runs = append([]ProjectRun{run}, runs...)
+	}
+	return runs, historyIter.Error()
+}
+
+// deleteProjectHistory deletes all the runs of the project
+func deleteProjectHistory(packageName string) {
+	historyIter := db.NewIterator(util.BytesPrefix([]byte(getHistoryPrefixKey(packageName))), nil)
+	for historyIter.Next() {
+		db.Delete(historyIter.Key(), nil)
+	}
+	historyIter.Release()
+}
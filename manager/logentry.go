@@ -0,0 +1,275 @@
+package manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Log levels of the structured log lines, the values are the pino and bunyan level values
+const (
+	LogLevelTrace = 10
+	LogLevelDebug = 20
+	LogLevelInfo  = 30
+	LogLevelWarn  = 40
+	LogLevelError = 50
+	LogLevelFatal = 60
+)
+
+// logLevelNames the names of the log levels
+var logLevelNames = map[int]string{
+	LogLevelTrace: "trace",
+	LogLevelDebug: "debug",
+	LogLevelInfo:  "info",
+	LogLevelWarn:  "warn",
+	LogLevelError: "error",
+	LogLevelFatal: "fatal",
+}
+
+// logLevelValues the values of the log level names, including the common aliases
+var logLevelValues = map[string]int{
+	"trace":    LogLevelTrace,
+	"debug":    LogLevelDebug,
+	"info":     LogLevelInfo,
+	"warn":     LogLevelWarn,
+	"warning":  LogLevelWarn,
+	"error":    LogLevelError,
+	"fatal":    LogLevelFatal,
+	"critical": LogLevelFatal,
+}
+
+// Field names of the structured log lines that are extracted to the log entry fields
+var (
+	logLevelFields   = []string{"level", "severity"}
+	logMessageFields = []string{"msg", "message"}
+	logTimeFields    = []string{"time", "timestamp"}
+)
+
+// logConditionOperators the operators of the log conditions, the longer operators are matched first
+var logConditionOperators = []string{">=", "<=", "!=", "=", ">", "<"}
+
+// LogEntry a structured (JSON) log line
+type LogEntry struct {
+	// Level the level name (trace, debug, info, warn, error or fatal)
+	Level string `json:"level,omitempty"`
+	// LevelValue the pino and bunyan level value of the level, 0 if the line has no level
+	LevelValue int    `json:"level_value,omitempty"`
+	Message    string `json:"message,omitempty"`
+	// Time the time field of the line or the time that the line is written at
+	Time *time.Time `json:"time,omitempty"`
+	// Fields the fields of the line besides the level, message and time fields
+	Fields map[string]interface{} `json:"fields,omitempty"`
+	// values all the fields of the line, they are matched by the log conditions
+	values map[string]interface{}
+}
+
+// LogCondition a condition on a field of the structured log lines (e.g. level>=warn, reqId=abc)
+type LogCondition struct {
+	// Field the field name, nested fields are separated by dots (e.g. req.method)
+	Field    string
+	Operator string
+	Value    string
+}
+
+// ParseLogCondition parses a condition of the structured log lines
+//
+// The level field is compared by the level order, other fields are compared as text by = and !=
+// and as numbers by the other operators
+func ParseLogCondition(expression string) (LogCondition, error) {
+	operatorIndex := strings.IndexAny(expression, "<>!=")
+	if operatorIndex <= 0 {
+		return LogCondition{}, fmt.Errorf("invalid log condition %s, a field, an operator and a value are expected (e.g. level>=warn)", expression)
+	}
+	condition := LogCondition{Field: strings.TrimSpace(expression[:operatorIndex])}
+	for _, operator := range logConditionOperators {
+		if strings.HasPrefix(expression[operatorIndex:], operator) {
+			condition.Operator = operator
+			condition.Value = strings.TrimSpace(expression[operatorIndex+len(operator):])
+			break
+		}
+	}
+	if condition.Operator == "" {
+		return LogCondition{}, fmt.Errorf("invalid log condition %s, the operator must be %s", expression, strings.Join(logConditionOperators, ", "))
+	}
+	if condition.Field == "level" {
+		if _, ok := parseLogLevel(condition.Value); !ok {
+			return LogCondition{}, fmt.Errorf("invalid log level %s", condition.Value)
+		}
+	}
+	return condition, nil
+}
+
+// match checks if the log entry matches the condition, lines that are not structured or that
+// have no value of the field are not matched
+func (condition LogCondition) match(entry *LogEntry) bool {
+	if entry == nil {
+		return false
+	}
+	if condition.Field == "level" {
+		if entry.LevelValue == 0 {
+			return false
+		}
+		levelValue, _ := parseLogLevel(condition.Value)
+		return compareLogValues(float64(entry.LevelValue), float64(levelValue), condition.Operator)
+	}
+	value, ok := entry.getValue(condition.Field)
+	if !ok {
+		return false
+	}
+	switch condition.Operator {
+	case "=":
+		return value == condition.Value
+	case "!=":
+		return value != condition.Value
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	conditionNumber, conditionErr := strconv.ParseFloat(condition.Value, 64)
+	return err == nil && conditionErr == nil && compareLogValues(number, conditionNumber, condition.Operator)
+}
+
+// compareLogValues compares the value with the condition value by the operator
+func compareLogValues(value float64, conditionValue float64, operator string) bool {
+	switch operator {
+	case "=":
+		return value == conditionValue
+	case "!=":
+		return value != conditionValue
+	case ">=":
+		return value >= conditionValue
+	case "<=":
+		return value <= conditionValue
+	case ">":
+		return value > conditionValue
+	case "<":
+		return value < conditionValue
+	}
+	return false
+}
+
+// getValue gets the text of a field of the log entry, nested fields are separated by dots
+func (entry *LogEntry) getValue(field string) (string, bool) {
+	var value interface{} = entry.values
+	for _, name := range strings.Split(field, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if value, ok = object[name]; !ok {
+			return "", false
+		}
+	}
+	switch typedValue := value.(type) {
+	case string:
+		return typedValue, true
+	case json.Number:
+		return typedValue.String(), true
+	}
+	valueBytes, _ := json.Marshal(value)
+	return string(valueBytes), true
+}
+
+// parseLogLevel gets the level value of a level name or a level value
+func parseLogLevel(level string) (int, bool) {
+	if levelValue, ok := logLevelValues[strings.ToLower(level)]; ok {
+		return levelValue, true
+	}
+	levelValue, err := strconv.Atoi(level)
+	return levelValue, err == nil && levelValue > 0
+}
+
+// getLogLevelName gets the name of the level value, custom levels get the name of the lower level
+func getLogLevelName(levelValue int) string {
+	if levelValue >= LogLevelFatal {
+		return logLevelNames[LogLevelFatal]
+	} else if levelValue < LogLevelTrace {
+		return logLevelNames[LogLevelTrace]
+	}
+	return logLevelNames[levelValue/10*10]
+}
+
+// newLogLine creates a log line of the project logs, JSON lines are parsed to log entries
+//
+// The timestamp of the line is printed in the date format, it is removed if there is no date format
+func newLogLine(stream string, text string, dateFormat string) LogLine {
+	lineTime, body, hasLineTime := splitLogLineTime(text)
+	logLine := LogLine{Stream: stream, Text: body}
+	if hasLineTime {
+		logLine.Time = &lineTime
+		if dateFormat != "" {
+			logLine.Text = lineTime.Local().Format(dateFormat) + logTimestampSeparator + body
+		}
+	}
+	logLine.Entry = parseLogEntry(body, logLine.Time)
+	return logLine
+}
+
+// parseLogEntry parses a JSON log line (e.g. pino and bunyan lines), returns nil if the line
+// is not a JSON object
+//
+// The lines are parsed when they are read, they are not indexed when they are written.
+// lineTime is the time that the line is written at, it is used if the line has no time field
+func parseLogEntry(body string, lineTime *time.Time) *LogEntry {
+	body = strings.TrimSpace(body)
+	if !strings.HasPrefix(body, "{") || !strings.HasSuffix(body, "}") {
+		return nil
+	}
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.UseNumber()
+	values := make(map[string]interface{})
+	if err := decoder.Decode(&values); err != nil {
+		return nil
+	}
+	entry := &LogEntry{Fields: make(map[string]interface{}), values: values}
+	indexedFields := make(map[string]bool)
+	for _, field := range logLevelFields {
+		if value, ok := values[field]; !ok || value == nil {
+			continue
+		}
+		if levelValue, ok := parseLogLevel(fmt.Sprint(values[field])); ok {
+			entry.LevelValue = levelValue
+			entry.Level = getLogLevelName(levelValue)
+			indexedFields[field] = true
+			break
+		}
+	}
+	for _, field := range logMessageFields {
+		if message, ok := values[field].(string); ok {
+			entry.Message = message
+			indexedFields[field] = true
+			break
+		}
+	}
+	for _, field := range logTimeFields {
+		if entryTime, ok := parseLogEntryTime(values[field]); ok {
+			entry.Time = &entryTime
+			indexedFields[field] = true
+			break
+		}
+	}
+	if entry.Time == nil {
+		entry.Time = lineTime
+	}
+	for field, value := range values {
+		if !indexedFields[field] {
+			entry.Fields[field] = value
+		}
+	}
+	return entry
+}
+
+// parseLogEntryTime parses the time field of a JSON log line, epoch milliseconds (pino) or an RFC 3339 time (bunyan)
+func parseLogEntryTime(value interface{}) (time.Time, bool) {
+	switch typedValue := value.(type) {
+	case json.Number:
+		if epochMillis, err := typedValue.Int64(); err == nil {
+			return time.UnixMilli(epochMillis), true
+		}
+	case string:
+		if entryTime, err := time.Parse(time.RFC3339Nano, typedValue); err == nil {
+			return entryTime, true
+		}
+	}
+	return time.Time{}, false
+}
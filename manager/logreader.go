@@ -0,0 +1,214 @@
+package manager
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"time"
+)
+
+// logReadChunkSize the size of the chunks that a log file is read backwards in
+const logReadChunkSize = 64 * 1024
+
+// logTimeFormats the time formats of the log time filters, besides durations before now
+var logTimeFormats = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// LogFilter filters the project log lines
+type LogFilter struct {
+	// Since only the lines that are written at or after since are matched, zero for no limit
+	Since time.Time
+	// Until only the lines that are written at or before until are matched, zero for no limit
+	Until time.Time
+	// Pattern only the lines that the pattern matches are matched, nil for all lines
+	Pattern *regexp.Regexp
+	// Conditions only the structured lines that all the conditions match are matched
+	Conditions []LogCondition
+}
+
+// hasTimeLimits checks if the filter matches the lines by their timestamps
+func (filter *LogFilter) hasTimeLimits() bool {
+	return filter != nil && (!filter.Since.IsZero() || !filter.Until.IsZero())
+}
+
+// matchText checks if the filter pattern matches the log line
+func (filter *LogFilter) matchText(text string) bool {
+	return filter == nil || filter.Pattern == nil || filter.Pattern.MatchString(text)
+}
+
+// matchEntry checks if the filter conditions match the structured log line
+func (filter *LogFilter) matchEntry(entry *LogEntry) bool {
+	if filter == nil {
+		return true
+	}
+	for _, condition := range filter.Conditions {
+		if !condition.match(entry) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseLogTime parses a time of the log filters, a duration (e.g. 10m) is the time before now
+//
+// Times without a time zone are in the local time zone
+func ParseLogTime(value string, now time.Time) (time.Time, error) {
+	if duration, err := time.ParseDuration(value); err == nil {
+		return now.Add(-duration), nil
+	}
+	for _, timeFormat := range logTimeFormats {
+		if logTime, err := time.ParseInLocation(timeFormat, value, time.Local); err == nil {
+			return logTime, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %s, a duration (e.g. 10m) or a time (e.g. 2006-01-02 15:04:05) is expected", value)
+}
+
+// reverseLineReader reads the lines of a file from its end to its start
+type reverseLineReader struct {
+	file *os.File
+	// offset the file offset that the file is read until, the pending bytes are after it
+	offset  int64
+	pending []byte
+	// started is true after the first line is read, the newline at the end of the file is not a line
+	started bool
+	done    bool
+}
+
+// newReverseLineReader reads the lines of the file before the size offset
+func newReverseLineReader(file *os.File, size int64) *reverseLineReader {
+	return &reverseLineReader{file: file, offset: size}
+}
+
+// readLine reads the previous line of the file, returns io.EOF after the first line of the file
+//
+// Lines that are longer than the max line size are truncated, their start (and their timestamp) is kept
+func (reader *reverseLineReader) readLine() (string, error) {
+	for !reader.done {
+		if lineStart := bytes.LastIndexByte(reader.pending, '\n'); lineStart >= 0 {
+			line := truncateLogLine(reader.pending[lineStart+1:])
+			reader.pending = reader.pending[:lineStart]
+			if !reader.started && len(line) == 0 {
+				reader.started = true
+				continue
+			}
+			reader.started = true
+			return string(line), nil
+		}
+		if reader.offset == 0 {
+			reader.done = true
+			if !reader.started && len(reader.pending) == 0 {
+				break
+			}
+			return string(truncateLogLine(reader.pending)), nil
+		}
+		// The pending bytes are the end of a line, the end of an oversized line is dropped
+		reader.pending = truncateLogLine(reader.pending)
+		chunkSize := int64(logReadChunkSize)
+		if reader.offset < chunkSize {
+			chunkSize = reader.offset
+		}
+		chunk := make([]byte, chunkSize, chunkSize+int64(len(reader.pending)))
+		if _, err := reader.file.ReadAt(chunk, reader.offset-chunkSize); err != nil {
+			return "", err
+		}
+		reader.offset -= chunkSize
+		reader.pending = append(chunk, reader.pending...)
+	}
+	return "", io.EOF
+}
+
+// truncateLogLine truncates the line to the max line size
+func truncateLogLine(line []byte) []byte {
+	if len(line) > maxLogLineSize {
+		return line[:maxLogLineSize]
+	}
+	return line
+}
+
+// readLogLines reads the last lines of the log file that the filter matches, all the lines are
+// read if numOfLines is 0 and no lines are read if it is negative
+//
+// The file is read backwards from its end, so the last lines of large logs are read without
+// reading the whole file. The time limits need the line timestamps, lines without a timestamp
+// have the time of the previous line. The timestamps are printed in the date format.
+// The lines that are written while the file is read are not read, returns the offset that the
+// lines are read until. A log file that is not created yet has no lines
+func readLogLines(path string, stream string, numOfLines int, dateFormat string, filter *LogFilter) ([]LogLine, int64, error) {
+	logLines := make([]LogLine, 0)
+	logFile, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return logLines, 0, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+	defer logFile.Close()
+	fileInfo, err := logFile.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	if numOfLines < 0 {
+		return logLines, fileInfo.Size(), nil
+	}
+	reader := newReverseLineReader(logFile, fileInfo.Size())
+	timeLimited := filter.hasTimeLimits()
+	// group the lines after a timestamped line in reverse order, they are matched by its time
+	group := make([]string, 0)
+	for numOfLines == 0 || len(logLines) < numOfLines {
+		line, err := reader.readLine()
+		if err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		var lineTime time.Time
+		if err == nil {
+			group = append(group, line)
+			var hasTime bool
+			if lineTime, _, hasTime = splitLogLineTime(line); timeLimited && !hasTime {
+				continue
+			}
+		}
+		if timeLimited && !filter.Until.IsZero() && lineTime.After(filter.Until) {
+			group = group[:0]
+			continue
+		}
+		// The lines are written in time order, so the previous lines are older too
+		if timeLimited && !filter.Since.IsZero() && lineTime.Before(filter.Since) {
+			break
+		}
+		for _, groupLine := range group {
+			if logLine := newLogLine(stream, groupLine, dateFormat); filter.matchText(logLine.Text) && filter.matchEntry(logLine.Entry) {
+				logLines = append(logLines, logLine)
+			}
+		}
+		group = group[:0]
+		if err == io.EOF {
+			break
+		}
+	}
+	if numOfLines > 0 && len(logLines) > numOfLines {
+		logLines = logLines[:numOfLines]
+	}
+	// The lines are read from the last line, they are reversed to the file order
+	for i, j := 0, len(logLines)-1; i < j; i, j = i+1, j-1 {
+		logLines[i], logLines[j] = logLines[j], logLines[i]
+	}
+	return logLines, fileInfo.Size(), nil
+}
+
+// validateLogFilter validates that the filter can be applied to the project logs
+func validateLogFilter(filter *LogFilter, dateFormat string) error {
+	if filter.hasTimeLimits() && dateFormat == "" {
+		return fmt.Errorf("log_date_format must be set to filter the logs by time")
+	}
+	if filter != nil && !filter.Since.IsZero() && !filter.Until.IsZero() && filter.Until.Before(filter.Since) {
+		return fmt.Errorf("until can't be before since")
+	}
+	return nil
+}
@@ -0,0 +1,411 @@
+package manager
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eladyarkoni/bpm/node"
+)
+
+const (
+	defaultLogMaxSize  = 10 * 1024 * 1024
+	defaultLogMaxFiles = 5
+	// rotatedLogTimeFormat the time format of the rotated log file names
+	rotatedLogTimeFormat = "20060102-150405"
+	// compressedLogExt the extension of the compressed rotated log files
+	compressedLogExt = ".gz"
+	// logLineTimeLayout the layout of the timestamps that prefix the log lines, the timestamps
+	// have a fixed width so they are parsed without the project date format
+	logLineTimeLayout = "2006-01-02T15:04:05.000000-07:00"
+	// logTimestampSeparator separates the timestamp of a log line from the line when it is read
+	logTimestampSeparator = ": "
+)
+
+// Log rotation intervals
+const (
+	LogIntervalHourly = "hourly"
+	LogIntervalDaily  = "daily"
+	LogIntervalWeekly = "weekly"
+)
+
+// logRotation the project log rotation policy with the defaults applied
+type logRotation struct {
+	// maxSize bytes of the log file that it is rotated at, 0 if there is no size rotation
+	maxSize  int64
+	interval string
+	maxFiles int
+	compress bool
+}
+
+// logWriter writes the output of the project processes to a project log file
+//
+// The output is appended to the log file across restarts, the log file is rotated by
+// its size and by the rotation interval
+type logWriter struct {
+	lock     sync.Mutex
+	path     string
+	rotation logRotation
+	// timestamps is true if the lines are prefixed by the time they are written at
+	timestamps bool
+	file       *os.File
+	size       int64
+	// atLineStart is true if the next output starts a new line
+	atLineStart bool
+	// nextRotation the time that the log file is rotated at, zero if there is no time rotation
+	nextRotation time.Time
+	// writeErr the last write error, it is logged once
+	writeErr error
+}
+
+// getLogRotation gets the project log rotation policy
+func getLogRotation(projectRotation node.LogRotation) logRotation {
+	rotation := logRotation{
+		maxSize:  projectRotation.MaxSize,
+		interval: projectRotation.Interval,
+		maxFiles: projectRotation.MaxFiles,
+		compress: projectRotation.Compress,
+	}
+	if rotation.maxSize == 0 {
+		rotation.maxSize = defaultLogMaxSize
+	} else if rotation.maxSize < 0 {
+		rotation.maxSize = 0
+	}
+	if rotation.maxFiles <= 0 {
+		rotation.maxFiles = defaultLogMaxFiles
+	}
+	return rotation
+}
+
+// validateLogRotation validates the project log rotation policy
+func validateLogRotation(projectRotation node.LogRotation) error {
+	switch projectRotation.Interval {
+	case "", LogIntervalHourly, LogIntervalDaily, LogIntervalWeekly:
+	default:
+		return fmt.Errorf("log_rotation.interval must be %s, %s or %s", LogIntervalHourly, LogIntervalDaily, LogIntervalWeekly)
+	}
+	if projectRotation.MaxFiles < 0 {
+		return fmt.Errorf("log_rotation.max_files can't be negative")
+	}
+	return nil
+}
+
+// getIntervalStart gets the start of the rotation interval that the time is in, weeks start on monday
+func getIntervalStart(interval string, t time.Time) time.Time {
+	year, month, day := t.Date()
+	switch interval {
+	case LogIntervalHourly:
+		return time.Date(year, month, day, t.Hour(), 0, 0, 0, t.Location())
+	case LogIntervalWeekly:
+		return time.Date(year, month, day-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// getNextIntervalStart gets the start of the rotation interval after the interval that the time is in
+func getNextIntervalStart(interval string, t time.Time) time.Time {
+	intervalStart := getIntervalStart(interval, t)
+	switch interval {
+	case LogIntervalHourly:
+		return intervalStart.Add(time.Hour)
+	case LogIntervalWeekly:
+		return intervalStart.AddDate(0, 0, 7)
+	}
+	return intervalStart.AddDate(0, 0, 1)
+}
+
+// openLogWriter opens the log file for appending
+//
+// A log file that is last written in a previous rotation interval is rotated before the
+// new output is appended
+func openLogWriter(path string, rotation logRotation, timestamps bool) (*logWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, err
+	}
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	writer := &logWriter{
+		path:        path,
+		rotation:    rotation,
+		timestamps:  timestamps,
+		file:        file,
+		size:        fileInfo.Size(),
+		atLineStart: true,
+	}
+	if rotation.interval != "" {
+		now := time.Now()
+		if writer.size > 0 && fileInfo.ModTime().Before(getIntervalStart(rotation.interval, now)) {
+			if err := writer.rotate(now); err != nil {
+				log.Printf("log %s can't be rotated: %s\n", path, err)
+			}
+		}
+		writer.nextRotation = getNextIntervalStart(rotation.interval, now)
+	}
+	return writer, nil
+}
+
+// Write writes the output to the log file, the log file is rotated before the write if needed
+// and the lines are prefixed by the time they are written at
+//
+// Write errors are logged and not returned, the process output must be drained even if the
+// log file can't be written
+func (writer *logWriter) Write(data []byte) (int, error) {
+	writer.lock.Lock()
+	defer writer.lock.Unlock()
+	if writer.file == nil {
+		return len(data), nil
+	}
+	now := time.Now()
+	sizeExceeded := writer.rotation.maxSize > 0 && writer.size+int64(len(data)) > writer.rotation.maxSize
+	intervalEnded := !writer.nextRotation.IsZero() && !now.Before(writer.nextRotation)
+	if writer.size > 0 && (sizeExceeded || intervalEnded) {
+		if err := writer.rotate(now); err != nil {
+			log.Printf("log %s can't be rotated: %s\n", writer.path, err)
+		}
+	}
+	if intervalEnded {
+		writer.nextRotation = getNextIntervalStart(writer.rotation.interval, now)
+	}
+	output := data
+	if writer.timestamps {
+		output = writer.addTimestamps(data, now)
+	}
+	written, err := writer.file.Write(output)
+	writer.size += int64(written)
+	if err != nil && (writer.writeErr == nil || err.Error() != writer.writeErr.Error()) {
+		log.Printf("log %s can't be written: %s\n", writer.path, err)
+	}
+	writer.writeErr = err
+	return len(data), nil
+}
+
+// addTimestamps prefixes the lines that start in the output with the time
+//
+// Lines are written as soon as they are received, a line that is written in parts is prefixed once
+func (writer *logWriter) addTimestamps(data []byte, now time.Time) []byte {
+	prefix := []byte(now.Format(logLineTimeLayout) + " ")
+	output := make([]byte, 0, len(data)+len(prefix))
+	for len(data) > 0 {
+		if writer.atLineStart {
+			output = append(output, prefix...)
+		}
+		lineEnd := bytes.IndexByte(data, '\n')
+		if lineEnd < 0 {
+			writer.atLineStart = false
+			return append(output, data...)
+		}
+		output = append(output, data[:lineEnd+1]...)
+		data = data[lineEnd+1:]
+		writer.atLineStart = true
+	}
+	return output
+}
+
+// rotate renames the log file to a rotated log file and opens a new log file
+//
+// The old rotated files are deleted and the rotated file is compressed in the background
+func (writer *logWriter) rotate(now time.Time) error {
+	rotatedPath := getRotatedLogPath(writer.path, now)
+	if err := os.Rename(writer.path, rotatedPath); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(writer.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	writer.file.Close()
+	writer.file = file
+	writer.size = 0
+	go maintainRotatedLogs(writer.path, writer.rotation)
+	return nil
+}
+
+// truncate clears the log file
+func (writer *logWriter) truncate() error {
+	writer.lock.Lock()
+	defer writer.lock.Unlock()
+	if writer.file == nil {
+		return os.Truncate(writer.path, 0)
+	}
+	writer.size = 0
+	return writer.file.Truncate(0)
+}
+
+// Close closes the log file
+func (writer *logWriter) Close() error {
+	writer.lock.Lock()
+	defer writer.lock.Unlock()
+	if writer.file == nil {
+		return nil
+	}
+	err := writer.file.Close()
+	writer.file = nil
+	return err
+}
+
+// getRotatedLogPath gets a free path of the rotated log file (e.g. app-20240102-150405.log)
+func getRotatedLogPath(logPath string, now time.Time) string {
+	ext := filepath.Ext(logPath)
+	rotatedBase := fmt.Sprintf("%s-%s", strings.TrimSuffix(logPath, ext), now.Format(rotatedLogTimeFormat))
+	rotatedPath := rotatedBase + ext
+	for i := 1; ; i++ {
+		_, err := os.Stat(rotatedPath)
+		_, compressedErr := os.Stat(rotatedPath + compressedLogExt)
+		if os.IsNotExist(err) && os.IsNotExist(compressedErr) {
+			return rotatedPath
+		}
+		rotatedPath = fmt.Sprintf("%s.%d%s", rotatedBase, i, ext)
+	}
+}
+
+// rotatedLog a rotated file of a log file
+type rotatedLog struct {
+	// path the path of the rotated file without the compressed extension
+	path string
+	time time.Time
+	// index the index of the files that are rotated at the same second, 0 for the first file
+	index int
+	// compressed is true if only the compressed file exists, a file that is being compressed
+	// has both files and it is not compressed yet
+	compressed bool
+}
+
+// remove deletes the rotated file and its compressed file
+func (rotated rotatedLog) remove() {
+	for _, path := range []string{rotated.path, rotated.path + compressedLogExt} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("log %s can't be deleted: %s\n", path, err)
+		}
+	}
+}
+
+// logMaintenanceLocks the locks of the log paths, the deleting and the compressing of the rotated
+// files of a log path are serialized
+var logMaintenanceLocks sync.Map
+
+// getLogMaintenanceLock gets the lock of the rotated files of the log path
+func getLogMaintenanceLock(logPath string) *sync.Mutex {
+	lock, _ := logMaintenanceLocks.LoadOrStore(logPath, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+// getRotatedLogs gets the rotated files of the log file, the oldest file first
+//
+// The files are ordered by their rotation time and index, a file and its compressed file are one rotated file
+func getRotatedLogs(logPath string) []rotatedLog {
+	ext := filepath.Ext(logPath)
+	rotatedPattern := regexp.MustCompile("^" + regexp.QuoteMeta(strings.TrimSuffix(filepath.Base(logPath), ext)) +
+		`-(\d{8}-\d{6})(?:\.(\d+))?` + regexp.QuoteMeta(ext) + "(" + regexp.QuoteMeta(compressedLogExt) + ")?$")
+	logDirEntries, _ := os.ReadDir(filepath.Dir(logPath))
+	rotatedLogsByPath := make(map[string]*rotatedLog)
+	for _, entry := range logDirEntries {
+		match := rotatedPattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		path := filepath.Join(filepath.Dir(logPath), strings.TrimSuffix(entry.Name(), compressedLogExt))
+		compressed := match[3] != ""
+		if rotated, ok := rotatedLogsByPath[path]; ok {
+			rotated.compressed = rotated.compressed && compressed
+			continue
+		}
+		rotatedTime, _ := time.ParseInLocation(rotatedLogTimeFormat, match[1], time.Local)
+		index, _ := strconv.Atoi(match[2])
+		rotatedLogsByPath[path] = &rotatedLog{path: path, time: rotatedTime, index: index, compressed: compressed}
+	}
+	rotatedLogs := make([]rotatedLog, 0, len(rotatedLogsByPath))
+	for _, rotated := range rotatedLogsByPath {
+		rotatedLogs = append(rotatedLogs, *rotated)
+	}
+	sort.Slice(rotatedLogs, func(i, j int) bool {
+		if !rotatedLogs[i].time.Equal(rotatedLogs[j].time) {
+			return rotatedLogs[i].time.Before(rotatedLogs[j].time)
+		}
+		return rotatedLogs[i].index < rotatedLogs[j].index
+	})
+	return rotatedLogs
+}
+
+// deleteOldRotatedLogs deletes the oldest rotated files of the log file, maxFiles files are kept
+//
+// The maintenance lock of the log path must be held
+func deleteOldRotatedLogs(logPath string, maxFiles int) []rotatedLog {
+	rotatedLogs := getRotatedLogs(logPath)
+	if len(rotatedLogs) <= maxFiles {
+		return rotatedLogs
+	}
+	for _, rotated := range rotatedLogs[:len(rotatedLogs)-maxFiles] {
+		rotated.remove()
+	}
+	return rotatedLogs[len(rotatedLogs)-maxFiles:]
+}
+
+// pruneRotatedLogs deletes the oldest rotated files of the log file, maxFiles files are kept
+func pruneRotatedLogs(logPath string, maxFiles int) {
+	lock := getLogMaintenanceLock(logPath)
+	lock.Lock()
+	defer lock.Unlock()
+	deleteOldRotatedLogs(logPath, maxFiles)
+}
+
+// maintainRotatedLogs deletes the oldest rotated files of the log file and compresses the other
+// rotated files if the rotation policy compresses them
+//
+// It runs after every rotation, the files of the rotations that are not maintained yet are maintained too
+func maintainRotatedLogs(logPath string, rotation logRotation) {
+	lock := getLogMaintenanceLock(logPath)
+	lock.Lock()
+	defer lock.Unlock()
+	rotatedLogs := deleteOldRotatedLogs(logPath, rotation.maxFiles)
+	if !rotation.compress {
+		return
+	}
+	for _, rotated := range rotatedLogs {
+		if rotated.compressed {
+			continue
+		}
+		if err := compressLogFile(rotated.path); err != nil {
+			log.Printf("log %s can't be compressed: %s\n", rotated.path, err)
+		}
+	}
+}
+
+// compressLogFile compresses the log file with gzip and deletes the uncompressed file
+func compressLogFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	compressedFile, err := os.OpenFile(path+compressedLogExt, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	gzipWriter := gzip.NewWriter(compressedFile)
+	_, err = io.Copy(gzipWriter, file)
+	if closeErr := gzipWriter.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := compressedFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + compressedLogExt)
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,323 @@
+package manager
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/eladyarkoni/bpm/node"
+	"github.com/hpcloud/tail"
+)
+
+// Project log streams
+const (
+	LogStreamOut = "out"
+	LogStreamErr = "err"
+)
+
+// maxLogLineSize the maximum size of a log line that is read
+const maxLogLineSize = 1024 * 1024
+
+// LogLine a line of the project logs
+type LogLine struct {
+	// Stream the output stream that the line is written to, out or err
+	Stream string `json:"stream"`
+	// Text the line without its timestamp, prefixed by the time in the project date format
+	Text string `json:"text"`
+	// Time the time that the line is written at, nil if the line has no timestamp
+	Time *time.Time `json:"time,omitempty"`
+	// Entry the fields of a JSON line, nil if the line is not structured
+	Entry *LogEntry `json:"entry,omitempty"`
+}
+
+// projectLogWriters the log writers of the project processes stdout and stderr
+type projectLogWriters struct {
+	out *logWriter
+	err *logWriter
+}
+
+var (
+	logWritersLock sync.Mutex
+	logWriters     = make(map[string]*projectLogWriters)
+)
+
+// resolveProjectLogPaths gets the stdout and stderr log file paths of the project, the default
+// log paths are used if the project has no log paths
+func resolveProjectLogPaths(project *node.Project) (string, string) {
+	logPath := project.LogPath
+	if logPath == "" {
+		logPath = getProjectLogPath(project.Name)
+	}
+	errorLogPath := project.ErrorLogPath
+	if errorLogPath == "" {
+		errorLogPath = getProjectErrorLogPath(project.Name)
+	}
+	return logPath, errorLogPath
+}
+
+// openProjectLogWriters opens the stdout and stderr log writers of the project processes
+//
+// The writers are registered until the processes exit, so the logs of a running project can be flushed
+func openProjectLogWriters(project *node.Project) (*projectLogWriters, error) {
+	logPath, errorLogPath := resolveProjectLogPaths(project)
+	if logPath == errorLogPath {
+		return nil, fmt.Errorf("log_path and error_log_path must be different files")
+	}
+	rotation := getLogRotation(project.LogRotation)
+	timestamps := project.LogDateFormat != ""
+	outWriter, err := openLogWriter(logPath, rotation, timestamps)
+	if err != nil {
+		return nil, err
+	}
+	errWriter, err := openLogWriter(errorLogPath, rotation, timestamps)
+	if err != nil {
+		outWriter.Close()
+		return nil, err
+	}
+	writers := &projectLogWriters{out: outWriter, err: errWriter}
+	logWritersLock.Lock()
+	logWriters[project.Name] = writers
+	logWritersLock.Unlock()
+	return writers, nil
+}
+
+// closeProjectLogWriters closes the log writers of the project processes
+func closeProjectLogWriters(packageName string, writers *projectLogWriters) {
+	logWritersLock.Lock()
+	if logWriters[packageName] == writers {
+		delete(logWriters, packageName)
+	}
+	logWritersLock.Unlock()
+	writers.out.Close()
+	writers.err.Close()
+}
+
+// FlushProjectLogs clears the project log files and deletes their rotated files
+//
+// The log files of a running project are cleared while the processes keep writing to them
+func FlushProjectLogs(packageName string) error {
+	projectData, err := GetProject(packageName)
+	if err != nil {
+		return fmt.Errorf("project is not found")
+	}
+	logPath, errorLogPath := resolveProjectLogPaths(projectData)
+	logWritersLock.Lock()
+	writers := logWriters[packageName]
+	logWritersLock.Unlock()
+	if writers != nil {
+		for _, writer := range []*logWriter{writers.out, writers.err} {
+			if err := writer.truncate(); err != nil {
+				return err
+			}
+			pruneRotatedLogs(writer.path, 0)
+		}
+		return nil
+	}
+	for _, path := range []string{logPath, errorLogPath} {
+		if err := os.Truncate(path, 0); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		pruneRotatedLogs(path, 0)
+	}
+	return nil
+}
+
+// GetProjectLogContent gets the last lines of the project logs that the filter matches
+//
+// The stream is out for the stdout log, err for the stderr log or empty for both logs merged
+// by the line timestamps. The filter is optional
+func GetProjectLogContent(packageName string, stream string, numOfLines int, filter *LogFilter) ([]LogLine, error) {
+	logFiles, dateFormat, err := getProjectLogFiles(packageName, stream)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateLogFilter(filter, dateFormat); err != nil {
+		return nil, err
+	}
+	logLines, _, err := readLastLogLines(logFiles, dateFormat, numOfLines, filter)
+	return logLines, err
+}
+
+// FollowProjectLogs sends the last lines of the project logs and the lines that are written
+// to the project logs after them, until done is closed
+//
+// The log files are followed across rotations, flushes and restarts of the project.
+// The stream is out, err or empty for both logs, the lines of both logs are sent as they are read.
+// The filter is optional, the new lines are written after since and they have no until limit
+func FollowProjectLogs(packageName string, stream string, numOfLines int, filter *LogFilter, done <-chan struct{}) (<-chan LogLine, error) {
+	logFiles, dateFormat, err := getProjectLogFiles(packageName, stream)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateLogFilter(filter, dateFormat); err != nil {
+		return nil, err
+	}
+	if filter != nil && !filter.Until.IsZero() {
+		return nil, fmt.Errorf("until can't be used to follow the logs")
+	}
+	lastLines, offsets, err := readLastLogLines(logFiles, dateFormat, numOfLines, filter)
+	if err != nil {
+		return nil, err
+	}
+	tails := make([]*tail.Tail, 0, len(logFiles))
+	for i, logFile := range logFiles {
+		logTail, err := tail.TailFile(logFile.path, tail.Config{
+			Location: &tail.SeekInfo{Offset: offsets[i], Whence: io.SeekStart},
+			ReOpen:   true,
+			Follow:   true,
+			Logger:   tail.DiscardingLogger,
+		})
+		if err != nil {
+			for _, startedTail := range tails {
+				startedTail.Stop()
+			}
+			return nil, err
+		}
+		tails = append(tails, logTail)
+	}
+	logLines := make(chan LogLine)
+	go func() {
+		defer close(logLines)
+	sendLastLines:
+		for _, logLine := range lastLines {
+			select {
+			case logLines <- logLine:
+			case <-done:
+				break sendLastLines
+			}
+		}
+		var followers sync.WaitGroup
+		for i := range tails {
+			followers.Add(1)
+			go func(logTail *tail.Tail, stream string) {
+				defer followers.Done()
+				go func() {
+					<-done
+					logTail.Stop()
+				}()
+				// The tail lines are drained until the tail is stopped
+				for line := range logTail.Lines {
+					if line.Err != nil {
+						continue
+					}
+					logLine := newLogLine(stream, line.Text, dateFormat)
+					if !filter.matchText(logLine.Text) || !filter.matchEntry(logLine.Entry) {
+						continue
+					}
+					select {
+					case logLines <- logLine:
+					case <-done:
+					}
+				}
+			}(tails[i], logFiles[i].stream)
+		}
+		followers.Wait()
+	}()
+	return logLines, nil
+}
+
+// projectLogFile a log file of a project stream
+type projectLogFile struct {
+	path   string
+	stream string
+}
+
+// getProjectLogFiles gets the log files of the project stream (out, err or empty for both) and
+// the date format of the log lines
+//
+// The log files of the last run are used, the log paths may be changed since it is started
+func getProjectLogFiles(packageName string, stream string) ([]projectLogFile, string, error) {
+	projectData, err := GetProject(packageName)
+	if err != nil {
+		return nil, "", fmt.Errorf("project is not found")
+	}
+	logPath, errorLogPath := resolveProjectLogPaths(projectData)
+	if projectState, _ := GetProjectState(packageName); projectState != nil && projectState.LogPath != "" {
+		logPath = projectState.LogPath
+		if projectState.ErrorLogPath != "" {
+			errorLogPath = projectState.ErrorLogPath
+		}
+	}
+	outFile := projectLogFile{path: logPath, stream: LogStreamOut}
+	errFile := projectLogFile{path: errorLogPath, stream: LogStreamErr}
+	switch stream {
+	case LogStreamOut:
+		return []projectLogFile{outFile}, projectData.LogDateFormat, nil
+	case LogStreamErr:
+		return []projectLogFile{errFile}, projectData.LogDateFormat, nil
+	case "":
+		return []projectLogFile{outFile, errFile}, projectData.LogDateFormat, nil
+	}
+	return nil, "", fmt.Errorf("unknown log stream %s", stream)
+}
+
+// readLastLogLines reads the last lines of the log files that the filter matches, the lines of
+// the stdout and stderr logs are merged by their timestamps
+//
+// Returns the offsets of the log files that the lines are read until
+func readLastLogLines(logFiles []projectLogFile, dateFormat string, numOfLines int, filter *LogFilter) ([]LogLine, []int64, error) {
+	var logLines []LogLine
+	offsets := make([]int64, len(logFiles))
+	for i, logFile := range logFiles {
+		fileLines, offset, err := readLogLines(logFile.path, logFile.stream, numOfLines, dateFormat, filter)
+		if err != nil {
+			return nil, nil, err
+		}
+		offsets[i] = offset
+		logLines = mergeLogLines(logLines, fileLines)
+	}
+	if numOfLines > 0 && len(logLines) > numOfLines {
+		logLines = logLines[len(logLines)-numOfLines:]
+	}
+	return logLines, offsets, nil
+}
+
+// splitLogLineTime splits the timestamp that prefixes the log line from the line
+func splitLogLineTime(text string) (time.Time, string, bool) {
+	timeSize := len(logLineTimeLayout)
+	if len(text) <= timeSize || text[timeSize] != ' ' {
+		return time.Time{}, text, false
+	}
+	lineTime, err := time.Parse(logLineTimeLayout, text[:timeSize])
+	if err != nil {
+		return time.Time{}, text, false
+	}
+	return lineTime, text[timeSize+1:], true
+}
+
+// getLogLinesTimes gets the times of the log lines, lines without a timestamp get the time
+// of the previous line
+func getLogLinesTimes(logLines []LogLine) []time.Time {
+	times := make([]time.Time, len(logLines))
+	var lastTime time.Time
+	for i, logLine := range logLines {
+		if logLine.Time != nil {
+			lastTime = *logLine.Time
+		}
+		times[i] = lastTime
+	}
+	return times
+}
+
+// mergeLogLines merges the stdout and stderr log lines by their timestamps
+//
+// Lines with the same time keep the stdout lines first, logs without timestamps are not
+// interleaved, they are merged in file order (the stdout lines and then the stderr lines)
+func mergeLogLines(outLines []LogLine, errLines []LogLine) []LogLine {
+	outTimes := getLogLinesTimes(outLines)
+	errTimes := getLogLinesTimes(errLines)
+	logLines := make([]LogLine, 0, len(outLines)+len(errLines))
+	i, j := 0, 0
+	for i < len(outLines) || j < len(errLines) {
+		if j == len(errLines) || (i < len(outLines) && !errTimes[j].Before(outTimes[i])) {
+			logLines = append(logLines, outLines[i])
+			i++
+		} else {
+			logLines = append(logLines, errLines[j])
+			j++
+		}
+	}
+	return logLines
+}
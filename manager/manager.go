@@ -2,34 +2,127 @@ package manager
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/eladyarkoni/bpm/node"
-	"github.com/hpcloud/tail"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 const (
-	levelDBPath      = "/tmp/bulk-pm.db"
 	projectPrefixKey = "project-"
 	statePrefixKey   = "state-"
 )
 
+// projectNamePattern the valid project names, project names are used in paths and urls
+var projectNamePattern = regexp.MustCompile(`^[A-Za-z0-9@_][A-Za-z0-9@._/-]*$`)
+
+// editableProjectFields the project fields that can be updated by UpdateProject, the name,
+// working directory and package are read from the project directory, the cluster processes
+// and the environment profile are set by scale and start
+var editableProjectFields = map[string]bool{
+	"start_mode":       true,
+	"interpreter":      true,
+	"node_path":        true,
+	"node_version":     true,
+	"node_args":        true,
+	"node_options":     true,
+	"interpreter_args": true,
+	"script":           true,
+	"args":             true,
+	"env":              true,
+	"env_file":         true,
+	"env_profiles":     true,
+	"log_path":         true,
+	"error_log_path":   true,
+	"log_date_format":  true,
+	"log_rotation":     true,
+	"stop_signal":      true,
+	"kill_timeout":     true,
+	"listen_timeout":   true,
+	"restart_policy":   true,
+}
+
 // LevelDB handler
 var db *leveldb.DB
 
+// projectStateLock serializes the project state updates
+var projectStateLock sync.Mutex
+
 // Init initialize the manager resources
 //
-// Manager is using levelDB to store its data
-func Init() {
-	db, _ = leveldb.OpenFile(levelDBPath, nil)
+// Manager is using levelDB to store its data, the database and the other manager files
+// are inside the home directory (see SetPaths). The database of the legacy location
+// (/tmp/bulk-pm.db) is copied to the home directory if it has no database yet
+func Init() error {
+	if err := paths.CreateDirs(); err != nil {
+		return err
+	}
+	if migrated, err := migrateLegacyDB(legacyDBPath, paths.DB); err != nil {
+		log.Printf("the database of %s can't be migrated: %s\n", legacyDBPath, err)
+	} else if migrated {
+		log.Printf("the database of %s is migrated to %s\n", legacyDBPath, paths.DB)
+	}
+	var err error
+	if db, err = leveldb.OpenFile(paths.DB, nil); err != nil {
+		return err
+	}
+	migrateProjects()
+	recoverProjectStates()
+	return nil
+}
+
+// recoverProjectStates fixes the states that are left in a transient status by a server that
+// is terminated, the project processes of the server are not running anymore
+//
+// Crashed projects are errored, other transient states are stopped, so the projects can be started again
+func recoverProjectStates() {
+	stateIter := db.NewIterator(util.BytesPrefix([]byte(statePrefixKey)), nil)
+	defer stateIter.Release()
+	for stateIter.Next() {
+		var projectState ProjectState
+		json.Unmarshal(stateIter.Value(), &projectState)
+		if projectState.IsRunning() && IsProcessRunning(projectState.PID) {
+			continue
+		}
+		switch projectState.GetStatus() {
+		case StatusStarting, StatusStopping, StatusRestarting:
+			projectState.Status = StatusStopped
+		case StatusCrashed:
+			projectState.Status = StatusErrored
+		default:
+			continue
+		}
+		projectState.PID = 0
+		SaveProjectState(strings.TrimPrefix(string(stateIter.Key()), statePrefixKey), &projectState)
+	}
+}
+
+// migrateProjects sets the name of projects that are saved before projects had names
+//
+// The package name was the project name
+func migrateProjects() {
+	projectIter := db.NewIterator(util.BytesPrefix([]byte(projectPrefixKey)), nil)
+	defer projectIter.Release()
+	for projectIter.Next() {
+		var projectData node.Project
+		json.Unmarshal(projectIter.Value(), &projectData)
+		if projectData.Name == "" {
+			projectData.Name = projectData.Package.Name
+			SaveProject(&projectData)
+		}
+	}
 }
 
 // ClearDB Clears all database keys and values
@@ -65,22 +158,176 @@ func GetProject(packageName string) (*node.Project, error) {
 // Node projects must have package.json file which contains the node package information
 // The package name is used as the node project name
 func AddProject(workingDir string) error {
+	warnings, err := RegisterProject(&node.Project{WorkingDir: workingDir})
+	for _, warning := range warnings {
+		log.Printf("project %s: %s\n", workingDir, warning)
+	}
+	return err
+}
+
+// RegisterProject adds a project to the manager
+//
+// If the project working directory has a package.json file, the project is a node project
+// and the package name is its default name. Other projects must have a name and a script.
+//
+// Returns warnings about the project that don't prevent adding it (e.g. no installed node
+// version satisfies the package engines.node)
+func RegisterProject(project *node.Project) ([]string, error) {
+	if err := loadProjectPackage(project); err != nil {
+		return nil, err
+	}
+	if err := validateProject(project); err != nil {
+		return nil, err
+	}
+	warnings := make([]string, 0)
+	if warning := checkNodeEngine(project); warning != "" {
+		warnings = append(warnings, warning)
+	}
+	return warnings, SaveProject(project)
+}
+
+// loadProjectPackage reads the package of the project working directory and sets the project name
+func loadProjectPackage(project *node.Project) error {
+	if project.WorkingDir == "" {
+		return fmt.Errorf("working_dir is empty")
+	}
+	packageFilePath := filepath.Join(project.WorkingDir, node.NodePackageFile)
+	if _, err := os.Stat(packageFilePath); err == nil || project.Script == "" {
+		projectPackage, err := readPackage(project.WorkingDir)
+		if err != nil {
+			return err
+		}
+		project.Package = *projectPackage
+	}
+	if project.Name == "" {
+		project.Name = project.Package.Name
+	}
+	if project.Name == "" {
+		return fmt.Errorf("project name is empty")
+	}
+	return nil
+}
+
+// readPackage reads and validates the package.json file of the working directory
+func readPackage(workingDir string) (*node.Package, error) {
 	packageFilePath := fmt.Sprintf("%s/%s", workingDir, node.NodePackageFile)
 	packageBytes, err := ioutil.ReadFile(packageFilePath)
 	if err != nil || packageBytes == nil {
-		return fmt.Errorf("%s is not found in %s", node.NodePackageFile, workingDir)
+		return nil, fmt.Errorf("%s is not found in %s", node.NodePackageFile, workingDir)
 	}
-	var projectObject node.Project
-	projectObject.WorkingDir = workingDir
-	json.Unmarshal(packageBytes, &projectObject.Package)
-	if projectObject.Package.Name == "" {
-		return fmt.Errorf("package name is empty")
+	var projectPackage node.Package
+	json.Unmarshal(packageBytes, &projectPackage)
+	if projectPackage.Name == "" {
+		return nil, fmt.Errorf("package name is empty")
 	}
-	if projectObject.Package.GetMainScript() == "" {
-		return fmt.Errorf("main script definition is mandatory")
+	if projectPackage.GetMainScript() == "" && projectPackage.GetStartScript() == "" {
+		return nil, fmt.Errorf("main script or start script definition is mandatory")
+	}
+	return &projectPackage, nil
+}
+
+// SaveProject saves the project model in the db
+func SaveProject(project *node.Project) error {
+	projectBytes, err := json.Marshal(project)
+	if err != nil {
+		return err
+	}
+	return db.Put([]byte(projectPrefixKey+project.Name), projectBytes, nil)
+}
+
+// UpdateProject updates the project configuration
+//
+// projectConfig is a json object with the project fields to update, fields that are
+// not included in the json object keep their current values. Only the editable project
+// fields can be updated.
+func UpdateProject(packageName string, projectConfig []byte) (*node.Project, error) {
+	projectObject, err := GetProject(packageName)
+	if err != nil {
+		return nil, fmt.Errorf("project is not found")
+	}
+	projectFields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(projectConfig, &projectFields); err != nil {
+		return nil, fmt.Errorf("invalid project configuration: %s", err)
+	}
+	for field := range projectFields {
+		if !editableProjectFields[field] {
+			return nil, fmt.Errorf("project field %s can't be updated", field)
+		}
+	}
+	// The fields are decoded into a new project, so the env maps and the lists are replaced and
+	// not merged. The log rotation and restart policy settings are merged, so one setting can be set
+	updatedProject := &node.Project{LogRotation: projectObject.LogRotation, RestartPolicy: projectObject.RestartPolicy}
+	if err := json.Unmarshal(projectConfig, updatedProject); err != nil {
+		return nil, fmt.Errorf("invalid project configuration: %s", err)
 	}
 	projectBytes, _ := json.Marshal(projectObject)
-	db.Put([]byte(projectPrefixKey+projectObject.Package.Name), projectBytes, nil)
+	updatedProjectBytes, _ := json.Marshal(updatedProject)
+	currentFields := make(map[string]json.RawMessage)
+	updatedFields := make(map[string]json.RawMessage)
+	json.Unmarshal(projectBytes, &currentFields)
+	json.Unmarshal(updatedProjectBytes, &updatedFields)
+	// Empty values are omitted from the updated fields, they are cleared
+	for field := range projectFields {
+		if updatedFields[field] != nil {
+			currentFields[field] = updatedFields[field]
+		} else {
+			delete(currentFields, field)
+		}
+	}
+	projectBytes, _ = json.Marshal(currentFields)
+	projectObject = &node.Project{}
+	if err := json.Unmarshal(projectBytes, projectObject); err != nil {
+		return nil, fmt.Errorf("invalid project configuration: %s", err)
+	}
+	if err := validateProject(projectObject); err != nil {
+		return nil, err
+	}
+	if err := SaveProject(projectObject); err != nil {
+		return nil, err
+	}
+	return projectObject, nil
+}
+
+// validateProject validates the project configuration
+func validateProject(project *node.Project) error {
+	if !projectNamePattern.MatchString(project.Name) {
+		return fmt.Errorf("invalid project name %s", project.Name)
+	}
+	if project.StopSignal != "" {
+		if _, err := ParseSignal(project.StopSignal); err != nil {
+			return err
+		}
+	}
+	switch project.StartMode {
+	case "", node.StartModeMain, node.StartModeScript:
+	default:
+		return fmt.Errorf("start_mode must be %s or %s", node.StartModeMain, node.StartModeScript)
+	}
+	if project.GetStartMode() == node.StartModeMain && project.GetScript() == "" {
+		return fmt.Errorf("project has no script")
+	}
+	if project.GetStartMode() == node.StartModeScript && project.Package.GetStartScript() == "" {
+		return fmt.Errorf("package has no start script")
+	}
+	if project.KillTimeout < 0 {
+		return fmt.Errorf("kill_timeout can't be negative")
+	}
+	if project.ClusterProcesses < 0 {
+		return fmt.Errorf("cluster_processes can't be negative")
+	}
+	if project.ListenTimeout < 0 {
+		return fmt.Errorf("listen_timeout can't be negative")
+	}
+	if _, ok := project.EnvProfiles[project.EnvProfile]; project.EnvProfile != "" && !ok {
+		return fmt.Errorf("environment profile %s is not found", project.EnvProfile)
+	}
+	if err := validateLogRotation(project.LogRotation); err != nil {
+		return err
+	}
+	policy := project.RestartPolicy
+	if policy.MinUptime < 0 || policy.MaxRestarts < 0 || policy.RestartWindow < 0 || policy.InitialBackoff < 0 || policy.MaxBackoff < 0 {
+		return fmt.Errorf("restart_policy values can't be negative")
+	}
 	return nil
 }
 
@@ -99,24 +346,38 @@ func RemoveProject(packageName string) error {
 		return deleteError
 	}
 	db.Delete([]byte(statePrefixKey+packageName), nil)
+	deleteProjectHistory(packageName)
+	removeProjectRuntimeDir(packageName)
 	return nil
 }
 
 // GetProjectState gets the project state
 func GetProjectState(packageName string) (*ProjectState, error) {
+	projectState, err := loadProjectState(packageName)
+	if err != nil {
+		return nil, err
+	}
+	// Check again with os to verify that the process is running
+	if projectState.IsRunning() && !IsProcessRunning(projectState.PID) {
+		return updateProjectState(packageName, func(projectState *ProjectState) error {
+			if projectState.IsRunning() && !IsProcessRunning(projectState.PID) {
+				projectState.PID = 0
+				projectState.Status = StatusStopped
+			}
+			return nil
+		})
+	}
+	return projectState, nil
+}
+
+// loadProjectState reads the project state from the db
+func loadProjectState(packageName string) (*ProjectState, error) {
 	projectStateData, err := db.Get([]byte(statePrefixKey+packageName), nil)
 	if err != nil {
 		return nil, err
 	}
 	var projectState ProjectState
 	json.Unmarshal(projectStateData, &projectState)
-	// Check again with os to verify that the process is running
-	if projectState.IsRunning() {
-		if !IsProcessRunning(projectState.PID) {
-			projectState.PID = 0
-			SaveProjectState(packageName, &projectState)
-		}
-	}
 	return &projectState, nil
 }
 
@@ -133,15 +394,45 @@ func SaveProjectState(packageName string, projectState *ProjectState) error {
 	return nil
 }
 
+// updateProjectState reads, updates and saves the project state atomically
+//
+// The update function gets the current project state (or a new stopped state) and can
+// return an error to cancel the update.
+// Returns a copy of the saved project state
+func updateProjectState(packageName string, update func(projectState *ProjectState) error) (*ProjectState, error) {
+	projectStateLock.Lock()
+	defer projectStateLock.Unlock()
+	projectState, err := loadProjectState(packageName)
+	if err != nil {
+		projectState = &ProjectState{Status: StatusStopped}
+	}
+	if err := update(projectState); err != nil {
+		return nil, err
+	}
+	if err := SaveProjectState(packageName, projectState); err != nil {
+		return nil, err
+	}
+	savedState := *projectState
+	return &savedState, nil
+}
+
 // StartProject starts the project processes
 //
 // This function is using go routine to start the project process and wait for it to finish
-// If the process is stopped, the function checks if process is stopped by kill signal or not.
-// If the process is not stopped from kill signal, it means that the process is crashed and should be restarted.
+// If the process is stopped, the function checks if process is stopped by StopProject or not.
+// If the process is not stopped by StopProject, it means that the process is crashed and should be restarted.
 //
 // If project should run in a cluster mode (clusterProcesses != 0) the method generates the cluster node
 // script and use it as the project main script.
 func StartProject(packageName string, clusterProcesses int, procStateChannel chan *ProjectState) error {
+	return startProject(packageName, clusterProcesses, procStateChannel, 0)
+}
+
+// startProject starts the project processes
+//
+// restartCount is the number of automatic restarts since the project is started by StartProject,
+// crashed processes are restarted according to the project restart policy.
+func startProject(packageName string, clusterProcesses int, procStateChannel chan *ProjectState, restartCount int) error {
 	projectData, projectDataErr := GetProject(packageName)
 	if projectDataErr != nil {
 		return fmt.Errorf("project is not found")
@@ -150,110 +441,332 @@ func StartProject(packageName string, clusterProcesses int, procStateChannel cha
 	if projectState != nil && projectState.IsRunning() {
 		return fmt.Errorf("project is already running")
 	}
-	mainScript := projectData.Package.GetMainScript()
-	if mainScript == "" {
-		return fmt.Errorf("project has no main script")
+	if restartCount == 0 {
+		resetRestartTracker(packageName)
+	}
+	if projectData.ClusterProcesses != clusterProcesses {
+		projectData.ClusterProcesses = clusterProcesses
+		SaveProject(projectData)
+	}
+	nodePath, nodePathErr := getProjectNodePath(projectData)
+	if nodePathErr != nil {
+		return nodePathErr
+	}
+	processEnv, envErr := getProjectProcessEnv(projectData, nodePath)
+	if envErr != nil {
+		return fmt.Errorf("can't load the project environment: %s", envErr)
 	}
-	if clusterProcesses > 0 {
-		scriptErr := CreateClusterModeScript(projectData, clusterProcesses)
-		if scriptErr != nil {
-			return fmt.Errorf("can't create the cluster mode script")
+	commandArgs, commandErr := getProjectCommand(projectData, nodePath, clusterProcesses)
+	if commandErr != nil {
+		return commandErr
+	}
+	_, statusErr := updateProjectState(packageName, func(projectState *ProjectState) error {
+		if projectState.IsRunning() {
+			return fmt.Errorf("project is already running")
+		}
+		if err := projectState.SetStatus(StatusStarting); err != nil {
+			return err
 		}
-		mainScript = ClusterModeScript
+		projectState.ClusterProcesses = clusterProcesses
+		projectState.RestartCount = restartCount
+		return nil
+	})
+	if statusErr != nil {
+		return statusErr
 	}
 
 	// Start scripts and monitor
 	go func() {
-		command := exec.Command("node", mainScript)
+		command := exec.Command(commandArgs[0], commandArgs[1:]...)
 		command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 		command.Dir = projectData.WorkingDir
-		logPath := fmt.Sprintf("/tmp/%s.log", packageName)
-		logFile, _ := os.Create(logPath)
-		command.Stdout = logFile
-		command.Stderr = logFile
+		command.Env = processEnv
+		// The process output is written by the manager, the log files are appended and rotated
+		processLogWriters, logErr := openProjectLogWriters(projectData)
+		if logErr != nil {
+			log.Printf("package %s is failed to open its log files: %s\n", packageName, logErr)
+			updateProjectState(packageName, func(projectState *ProjectState) error {
+				return projectState.SetStatus(StatusErrored)
+			})
+			return
+		}
+		command.Stdout = processLogWriters.out
+		command.Stderr = processLogWriters.err
+		command.WaitDelay = processOutputWaitDelay
 		runError := command.Start()
 		if runError != nil {
+			closeProjectLogWriters(packageName, processLogWriters)
+			log.Printf("package %s is failed to start: %s\n", packageName, runError)
+			updateProjectState(packageName, func(projectState *ProjectState) error {
+				return projectState.SetStatus(StatusErrored)
+			})
 			return
 		}
-		runningProjectState := &ProjectState{
-			PID:       command.Process.Pid,
-			LogPath:   logPath,
-			StartTime: time.Now(),
-		}
-		SaveProjectState(packageName, runningProjectState)
+		proc := registerProcess(packageName, command.Process.Pid)
+		runningProjectState, _ := updateProjectState(packageName, func(projectState *ProjectState) error {
+			projectState.PID = command.Process.Pid
+			projectState.LogPath = processLogWriters.out.path
+			projectState.ErrorLogPath = processLogWriters.err.path
+			projectState.StartTime = time.Now()
+			projectState.EndTime = time.Time{}
+			return projectState.SetStatus(StatusOnline)
+		})
 		if procStateChannel != nil {
 			procStateChannel <- runningProjectState
 		}
 		// Wait for the process to finish
 		procError := command.Wait()
+		closeProjectLogWriters(packageName, processLogWriters)
+		// Processes that are left by the project may keep the output open after it exited
+		if errors.Is(procError, exec.ErrWaitDelay) {
+			procError = nil
+		}
 		// Process is finished, lets check the cause of this
-		runningProjectState.EndTime = time.Now()
-		runningProjectState.PID = 0
-		SaveProjectState(packageName, runningProjectState)
+		stopped := unregisterProcess(packageName, proc)
+		removeProjectRuntimeDir(packageName)
+		crashed := procError != nil && !stopped
+		exitedProjectState, _ := updateProjectState(packageName, func(projectState *ProjectState) error {
+			projectState.EndTime = time.Now()
+			projectState.PID = 0
+			projectState.LastExitCode = command.ProcessState.ExitCode()
+			projectState.LastExitSignal = ""
+			if waitStatus, ok := command.ProcessState.Sys().(syscall.WaitStatus); ok && waitStatus.Signaled() {
+				projectState.LastExitSignal = SignalName(waitStatus.Signal())
+			}
+			exitStatus := StatusStopped
+			if crashed {
+				exitStatus = StatusCrashed
+			}
+			// The process is exited anyway, the exit status is saved even if the transition is not expected
+			if err := projectState.SetStatus(exitStatus); err != nil {
+				log.Printf("package %s: %s\n", packageName, err)
+				projectState.Status = exitStatus
+			}
+			return nil
+		})
+		close(proc.done)
 		if procStateChannel != nil {
-			procStateChannel <- runningProjectState
+			procStateChannel <- exitedProjectState
 		}
-		if procError != nil {
-			errorCode := command.ProcessState.Sys().(syscall.WaitStatus)
-			if errorCode != 9 && errorCode != 0 {
-				// Process is terminated not by kill command, lets restart it
-				log.Printf("Process %d of package %s is crashed, autorestart is activated\n", command.Process.Pid, packageName)
-				autoRestartErr := StartProject(packageName, clusterProcesses, procStateChannel)
+		projectRun := &ProjectRun{
+			PID:       command.Process.Pid,
+			StartTime: exitedProjectState.StartTime,
+			EndTime:   exitedProjectState.EndTime,
+			ExitCode:  exitedProjectState.LastExitCode,
+			Signal:    exitedProjectState.LastExitSignal,
+		}
+		if !crashed {
+			saveProjectRun(packageName, projectRun)
+			return
+		}
+		// Process is terminated not by StopProject, lets restart it
+		// The restart is scheduled while the state is locked, so the restart can't start before
+		// the restarting status is saved
+		uptime := exitedProjectState.EndTime.Sub(exitedProjectState.StartTime)
+		updateProjectState(packageName, func(projectState *ProjectState) error {
+			restartDelay, shouldRestart := scheduleRestart(packageName, projectData.RestartPolicy, uptime, func() {
+				// The cluster processes number may be changed by ScaleProject while running
+				if restartProjectData, err := GetProject(packageName); err == nil {
+					clusterProcesses = restartProjectData.ClusterProcesses
+				}
+				autoRestartErr := startProject(packageName, clusterProcesses, procStateChannel, restartCount+1)
 				if autoRestartErr != nil {
-					log.Printf("package %s is failed to auto restart itself\n", packageName)
+					log.Printf("package %s is failed to auto restart itself: %s\n", packageName, autoRestartErr)
+					// The project is not restarted, it is errored instead of waiting for a restart
+					updateProjectState(packageName, func(projectState *ProjectState) error {
+						if projectState.GetStatus() != StatusRestarting {
+							return nil
+						}
+						projectRun.Restarted = false
+						saveProjectRun(packageName, projectRun)
+						return projectState.SetStatus(StatusErrored)
+					})
 				}
+			})
+			projectRun.Restarted = shouldRestart
+			saveProjectRun(packageName, projectRun)
+			if !shouldRestart {
+				log.Printf("Process %d of package %s is crashed, restarts limit is reached, the project is errored\n", command.Process.Pid, packageName)
+				return projectState.SetStatus(StatusErrored)
 			}
-		}
+			log.Printf("Process %d of package %s is crashed, autorestart in %s\n", command.Process.Pid, packageName, restartDelay)
+			return projectState.SetStatus(StatusRestarting)
+		})
 	}()
 	return nil
 }
 
 // StopProject stops the project processes
-func StopProject(packageName string) error {
+//
+// The project stop signal (SIGTERM by default) is sent to the project process group, if the
+// process group is still alive after the project kill timeout, it is killed with SIGKILL.
+// This function blocks until the process group is gone and returns the signal that ended it.
+func StopProject(packageName string) (syscall.Signal, error) {
+	// A crashed project that is waiting to be restarted is stopped by canceling the restart
+	restartCanceled := resetRestartTracker(packageName)
+	projectState, _ := GetProjectState(packageName)
+	if projectState == nil || !projectState.IsRunning() {
+		if !restartCanceled {
+			return 0, fmt.Errorf("project is not running")
+		}
+		_, err := updateProjectState(packageName, func(projectState *ProjectState) error {
+			return projectState.SetStatus(StatusStopped)
+		})
+		return 0, err
+	}
+	stopSignal := defaultStopSignal
+	killTimeout := defaultKillTimeout
+	if projectData, err := GetProject(packageName); err == nil {
+		if projectData.StopSignal != "" {
+			stopSignal, _ = ParseSignal(projectData.StopSignal)
+		}
+		if projectData.KillTimeout > 0 {
+			killTimeout = projectData.KillTimeout
+		}
+	}
+	// The process is marked as stopping only if it is stopped, otherwise its crash is not restarted
+	var proc *projectProcess
+	_, statusErr := updateProjectState(packageName, func(projectState *ProjectState) error {
+		if err := projectState.SetStatus(StatusStopping); err != nil {
+			return err
+		}
+		proc = markProcessStopping(packageName)
+		return nil
+	})
+	if statusErr != nil {
+		return 0, statusErr
+	}
+	endSignal, err := stopProcessGroup(projectState.PID, stopSignal, time.Duration(killTimeout)*time.Millisecond)
+	if err != nil {
+		return 0, err
+	}
+	if proc != nil {
+		// Wait for the monitor to save the stopped state
+		<-proc.done
+	} else {
+		// The process is not monitored by this server instance
+		removeProjectRuntimeDir(packageName)
+		updateProjectState(packageName, func(projectState *ProjectState) error {
+			projectState.PID = 0
+			projectState.EndTime = time.Now()
+			return projectState.SetStatus(StatusStopped)
+		})
+	}
+	return endSignal, nil
+}
+
+// RestartProject restarts the project processes
+//
+// If the project is running, it is stopped and started again, after its exit is confirmed,
+// with the same number of cluster processes.
+func RestartProject(packageName string, procStateChannel chan *ProjectState) error {
+	projectData, projectDataErr := GetProject(packageName)
+	if projectDataErr != nil {
+		return fmt.Errorf("project is not found")
+	}
+	clusterProcesses := projectData.ClusterProcesses
+	projectState, _ := GetProjectState(packageName)
+	if projectState != nil {
+		if projectState.IsRunning() {
+			if _, stopErr := StopProject(packageName); stopErr != nil {
+				return stopErr
+			}
+		}
+	}
+	return StartProject(packageName, clusterProcesses, procStateChannel)
+}
+
+// ReloadProject reloads the project cluster workers without downtime
+//
+// The cluster master process replaces the workers one at a time, so there are always
+// workers that accept connections.
+// Only projects that are running in cluster mode can be reloaded.
+func ReloadProject(packageName string) error {
+	projectData, projectDataErr := GetProject(packageName)
+	if projectDataErr != nil {
+		return fmt.Errorf("project is not found")
+	}
 	projectState, _ := GetProjectState(packageName)
 	if projectState == nil || !projectState.IsRunning() {
 		return fmt.Errorf("project is not running")
 	}
-	// Negative PID value is used to stop the process group (process and its childs)
-	syscall.Kill(-projectState.PID, syscall.SIGKILL)
-	return nil
+	if projectState.ClusterProcesses == 0 {
+		return fmt.Errorf("project is not running in cluster mode, use restart instead")
+	}
+	listenTimeout := defaultListenTimeout
+	if projectData.ListenTimeout > 0 {
+		listenTimeout = projectData.ListenTimeout
+	}
+	killTimeout := defaultKillTimeout
+	if projectData.KillTimeout > 0 {
+		killTimeout = projectData.KillTimeout
+	}
+	// Each worker is replaced after the new worker is listening and the old worker is exited
+	reloadTimeout := time.Duration(projectState.ClusterProcesses*(listenTimeout+killTimeout))*time.Millisecond + controlDialTimeout
+	_, err := sendClusterCommand(packageName, map[string]string{"command": "reload"}, reloadTimeout)
+	return err
+}
+
+// ScaleProject changes the number of the project cluster mode processes while running
+//
+// The cluster master process forks new workers or stops the extra workers, the new number of
+// processes is saved in the project and is used when the project is started again.
+func ScaleProject(packageName string, clusterProcesses int) error {
+	projectData, projectDataErr := GetProject(packageName)
+	if projectDataErr != nil {
+		return fmt.Errorf("project is not found")
+	}
+	if clusterProcesses < 1 {
+		return fmt.Errorf("number of cluster processes must be at least 1")
+	}
+	projectState, _ := GetProjectState(packageName)
+	if projectState == nil || !projectState.IsRunning() {
+		return fmt.Errorf("project is not running")
+	}
+	if projectState.ClusterProcesses == 0 {
+		return fmt.Errorf("project is not running in cluster mode")
+	}
+	killTimeout := defaultKillTimeout
+	if projectData.KillTimeout > 0 {
+		killTimeout = projectData.KillTimeout
+	}
+	scaleTimeout := time.Duration(killTimeout)*time.Millisecond + controlDialTimeout
+	_, err := sendClusterCommand(packageName, map[string]interface{}{"command": "scale", "processes": clusterProcesses}, scaleTimeout)
+	if err != nil {
+		return err
+	}
+	projectData.ClusterProcesses = clusterProcesses
+	if err := SaveProject(projectData); err != nil {
+		return err
+	}
+	// The state may be changed while the project is scaled, only the number of processes is updated
+	_, err = updateProjectState(packageName, func(currentState *ProjectState) error {
+		if currentState.IsRunning() {
+			currentState.ClusterProcesses = clusterProcesses
+		}
+		return nil
+	})
+	return err
 }
 
-// GetStatus gets all project status as a dictionary of package names and project state
+// GetStatus gets all project status as a dictionary of project names and project state
 func GetStatus() map[string]ProjectState {
 	stateMap := make(map[string]ProjectState)
 	projectIter := db.NewIterator(util.BytesPrefix([]byte(projectPrefixKey)), nil)
 	for projectIter.Next() {
 		var projectData node.Project
 		json.Unmarshal(projectIter.Value(), &projectData)
-		projectState, _ := GetProjectState(projectData.Package.Name)
+		projectState, _ := GetProjectState(projectData.Name)
 		if projectState == nil {
 			projectState = &ProjectState{
-				PID: 0,
+				PID:    0,
+				Status: StatusStopped,
 			}
+		} else if projectState.IsRunning() && projectState.ClusterProcesses > 0 {
+			projectState.Workers, _ = GetProjectWorkers(projectData.Name)
 		}
-		stateMap[projectData.Package.Name] = *projectState
+		stateMap[projectData.Name] = *projectState
 	}
 	projectIter.Release()
 	return stateMap
 }
-
-// GetProjectLogContent gets last lines of the project log
-func GetProjectLogContent(packageName string, numOfLines int) ([]string, error) {
-	projState, projStateErr := GetProjectState(packageName)
-	if projStateErr != nil {
-		return nil, fmt.Errorf("Can't get project status")
-	}
-	if projState.LogPath == "" {
-		return nil, fmt.Errorf("Logfile is not created")
-	}
-	t, err := tail.TailFile(projState.LogPath, tail.Config{Follow: false, MaxLineSize: numOfLines})
-	if err != nil {
-		return nil, err
-	}
-	logLines := make([]string, 0)
-	for line := range t.Lines {
-		logLines = append(logLines, line.Text)
-	}
-	return logLines, nil
-}
@@ -1,15 +1,27 @@
 package manager
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/eladyarkoni/bpm/node"
 )
 
 var testProjectDirectory = filepath.Join(os.Getenv("GOPATH"), "/src/github.com/eladyarkoni/bpm/testdata/ExpressProject/")
 var testProjectPackageName = "express-example-project"
+var testCrashProjectDirectory = filepath.Join(os.Getenv("GOPATH"), "/src/github.com/eladyarkoni/bpm/testdata/CrashProject/")
+var testCrashProjectPackageName = "crash-example-project"
 
 func init() {
+	SetPaths(NewPaths(filepath.Join(os.TempDir(), "bpm-test")))
+	// The database of the legacy location is not migrated to the test home directory
+	legacyDBPath = filepath.Join(os.TempDir(), "bpm-test-legacy.db")
 	Init()
 }
 
@@ -23,6 +35,64 @@ func TestAddingAProject(t *testing.T) {
 	}
 }
 
+func TestGettingTheHomeDir(t *testing.T) {
+	userHomeDir := t.TempDir()
+	for _, test := range []struct {
+		bpmHome     string
+		xdgDataHome string
+		expected    string
+	}{
+		{"/srv/bpm", "/data", "/srv/bpm"},
+		{"/srv/bpm", "", "/srv/bpm"},
+		{"", "/data", "/data/bpm"},
+		{"", "", filepath.Join(userHomeDir, ".local", "share", "bpm")},
+	} {
+		t.Setenv("HOME", userHomeDir)
+		t.Setenv(homeEnv, test.bpmHome)
+		t.Setenv("XDG_DATA_HOME", test.xdgDataHome)
+		if homeDir := GetHomeDir(); homeDir != test.expected {
+			t.Fatalf("BPM_HOME %q and XDG_DATA_HOME %q: expected home %s, got: %s", test.bpmHome, test.xdgDataHome, test.expected, homeDir)
+		}
+	}
+	if homePaths := NewPaths("/srv/bpm"); homePaths.DB != "/srv/bpm/db" || homePaths.Socket != "/srv/bpm/bpm.sock" {
+		t.Fatalf("home paths should be inside the home directory, got: %+v", homePaths)
+	}
+}
+
+func TestMigratingTheLegacyDB(t *testing.T) {
+	legacyPath := filepath.Join(t.TempDir(), "bulk-pm.db")
+	os.MkdirAll(legacyPath, 0700)
+	os.WriteFile(filepath.Join(legacyPath, "CURRENT"), []byte("MANIFEST-000001\n"), 0600)
+	os.WriteFile(filepath.Join(legacyPath, "000001.log"), []byte("legacy data"), 0600)
+	existingDBPath := filepath.Join(t.TempDir(), "db")
+	os.MkdirAll(existingDBPath, 0700)
+	for _, test := range []struct {
+		name       string
+		legacyPath string
+		dbPath     string
+		migrated   bool
+	}{
+		{"no legacy database", filepath.Join(t.TempDir(), "missing.db"), filepath.Join(t.TempDir(), "db"), false},
+		{"existing database", legacyPath, existingDBPath, false},
+		{"new database", legacyPath, filepath.Join(t.TempDir(), "db"), true},
+	} {
+		migrated, err := migrateLegacyDB(test.legacyPath, test.dbPath)
+		if err != nil || migrated != test.migrated {
+			t.Fatalf("%s: expected migrated %t, got: %t (%v)", test.name, test.migrated, migrated, err)
+		}
+		content, _ := os.ReadFile(filepath.Join(test.dbPath, "000001.log"))
+		if test.migrated != (string(content) == "legacy data") {
+			t.Fatalf("%s: expected the legacy files to be copied %t, got: %q", test.name, test.migrated, content)
+		}
+		if _, err := os.Stat(test.dbPath + ".migrating"); !os.IsNotExist(err) {
+			t.Fatalf("%s: temporary migration directory should be removed", test.name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(legacyPath, "000001.log")); err != nil {
+		t.Fatalf("legacy database should be kept: %s", err)
+	}
+}
+
 func TestGetAddedProject(t *testing.T) {
 	ClearDB()
 	AddProject(testProjectDirectory)
@@ -75,11 +145,15 @@ func TestRunningACreatedProject(t *testing.T) {
 	}
 
 	// Now, try to stop the project process
-	stopProjectErr := StopProject(testProjectPackageName)
+	stopSignal, stopProjectErr := StopProject(testProjectPackageName)
 	if stopProjectErr != nil {
 		t.Fatalf("could not stopping the project due to error: %s", stopProjectErr)
 		t.FailNow()
 	}
+	if stopSignal != syscall.SIGTERM {
+		t.Fatalf("project should be stopped by SIGTERM, stopped by: %s", SignalName(stopSignal))
+		t.FailNow()
+	}
 
 	projectStoppedState := <-receivingProjStateChan
 	if projectStoppedState.IsRunning() {
@@ -87,3 +161,860 @@ func TestRunningACreatedProject(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestParseSignal(t *testing.T) {
+	for _, name := range []string{"SIGINT", "int", "2"} {
+		sig, err := ParseSignal(name)
+		if err != nil || sig != syscall.SIGINT {
+			t.Fatalf("%s should be parsed as SIGINT, got: %v, %v", name, sig, err)
+		}
+	}
+	if _, err := ParseSignal("SIGNOPE"); err == nil {
+		t.Fatal("unknown signal should not be parsed")
+	}
+}
+
+func TestRestartingARunningProject(t *testing.T) {
+	ClearDB()
+	AddProject(testProjectDirectory)
+	receivingProjStateChan := make(chan *ProjectState)
+	defer close(receivingProjStateChan)
+
+	if err := StartProject(testProjectPackageName, 0, receivingProjStateChan); err != nil {
+		t.Fatalf("project is failed to start: %s", err)
+	}
+	startedState := <-receivingProjStateChan
+
+	if err := RestartProject(testProjectPackageName, receivingProjStateChan); err != nil {
+		t.Fatalf("project is failed to restart: %s", err)
+	}
+	// The stopped state of the first process and the running state of the new process
+	var restartedState *ProjectState
+	for i := 0; i < 2; i++ {
+		if projectState := <-receivingProjStateChan; projectState.IsRunning() {
+			restartedState = projectState
+		}
+	}
+	if restartedState == nil || restartedState.PID == startedState.PID {
+		t.Fatal("project is not restarted with a new process")
+	}
+
+	if _, err := StopProject(testProjectPackageName); err != nil {
+		t.Fatalf("could not stopping the project due to error: %s", err)
+	}
+	<-receivingProjStateChan
+}
+
+func TestReloadingAClusterModeProject(t *testing.T) {
+	ClearDB()
+	AddProject(testProjectDirectory)
+	receivingProjStateChan := make(chan *ProjectState)
+	defer close(receivingProjStateChan)
+
+	if err := StartProject(testProjectPackageName, 2, receivingProjStateChan); err != nil {
+		t.Fatalf("project is failed to start: %s", err)
+	}
+	<-receivingProjStateChan
+
+	if err := ReloadProject(testProjectPackageName); err != nil {
+		t.Fatalf("project is failed to reload: %s", err)
+	}
+
+	if _, err := StopProject(testProjectPackageName); err != nil {
+		t.Fatalf("could not stopping the project due to error: %s", err)
+	}
+	<-receivingProjStateChan
+}
+
+func TestReloadingAClusterWorkerThatDoesNotListen(t *testing.T) {
+	ClearDB()
+	projectDirectory := t.TempDir()
+	os.WriteFile(filepath.Join(projectDirectory, "package.json"), []byte(`{"name": "idle-example-project", "main": "index.js"}`), 0640)
+	os.WriteFile(filepath.Join(projectDirectory, "index.js"), []byte("setInterval(function() {}, 1000);\n"), 0640)
+	AddProject(projectDirectory)
+	UpdateProject("idle-example-project", []byte(`{"listen_timeout": 300, "kill_timeout": 500}`))
+	receivingProjStateChan := make(chan *ProjectState)
+	defer close(receivingProjStateChan)
+
+	if err := StartProject("idle-example-project", 2, receivingProjStateChan); err != nil {
+		t.Fatalf("project is failed to start: %s", err)
+	}
+	<-receivingProjStateChan
+	workers, _ := GetProjectWorkers("idle-example-project")
+	if err := ReloadProject("idle-example-project"); err == nil {
+		t.Fatal("reload should fail if the new worker doesn't listen")
+	}
+	reloadedWorkers, _ := GetProjectWorkers("idle-example-project")
+	if fmt.Sprint(reloadedWorkers) != fmt.Sprint(workers) {
+		t.Fatalf("old workers should be kept, expected: %v, got: %v", workers, reloadedWorkers)
+	}
+
+	if _, err := StopProject("idle-example-project"); err != nil {
+		t.Fatalf("could not stopping the project due to error: %s", err)
+	}
+	<-receivingProjStateChan
+}
+
+func TestScalingAClusterModeProject(t *testing.T) {
+	ClearDB()
+	AddProject(testProjectDirectory)
+	UpdateProject(testProjectPackageName, []byte(`{"node_args": ["--max-old-space-size=256"]}`))
+	receivingProjStateChan := make(chan *ProjectState)
+	defer close(receivingProjStateChan)
+
+	if err := StartProject(testProjectPackageName, 2, receivingProjStateChan); err != nil {
+		t.Fatalf("project is failed to start: %s", err)
+	}
+	<-receivingProjStateChan
+
+	for _, clusterProcesses := range []int{3, 1} {
+		if err := ScaleProject(testProjectPackageName, clusterProcesses); err != nil {
+			t.Fatalf("project is failed to scale: %s", err)
+		}
+		workers, err := GetProjectWorkers(testProjectPackageName)
+		if err != nil || len(workers) != clusterProcesses {
+			t.Fatalf("project should have %d workers, got: %v, %v", clusterProcesses, workers, err)
+		}
+	}
+	workers, _ := GetProjectWorkers(testProjectPackageName)
+	workerCommandLine, _ := os.ReadFile(fmt.Sprintf("/proc/%d/cmdline", workers[0].PID))
+	if !strings.Contains(string(workerCommandLine), "--max-old-space-size=256") {
+		t.Fatalf("cluster workers should run with the project node args, got: %q", workerCommandLine)
+	}
+	projectModel, _ := GetProject(testProjectPackageName)
+	if projectModel.ClusterProcesses != 1 {
+		t.Fatalf("project cluster processes should be saved, got: %d", projectModel.ClusterProcesses)
+	}
+
+	if _, err := StopProject(testProjectPackageName); err != nil {
+		t.Fatalf("could not stopping the project due to error: %s", err)
+	}
+	<-receivingProjStateChan
+}
+
+func TestRespawningADeadClusterWorker(t *testing.T) {
+	ClearDB()
+	AddProject(testProjectDirectory)
+	receivingProjStateChan := make(chan *ProjectState)
+	defer close(receivingProjStateChan)
+
+	if err := StartProject(testProjectPackageName, 2, receivingProjStateChan); err != nil {
+		t.Fatalf("project is failed to start: %s", err)
+	}
+	<-receivingProjStateChan
+
+	workers, err := GetProjectWorkers(testProjectPackageName)
+	if err != nil || len(workers) != 2 {
+		t.Fatalf("project should have 2 workers, got: %v, %v", workers, err)
+	}
+	deadWorker := workers[0]
+	syscall.Kill(deadWorker.PID, syscall.SIGKILL)
+	var respawnedWorker *WorkerState
+	for i := 0; i < 50 && respawnedWorker == nil; i++ {
+		time.Sleep(100 * time.Millisecond)
+		workers, _ = GetProjectWorkers(testProjectPackageName)
+		for _, worker := range workers {
+			if worker.ID == deadWorker.ID && worker.PID != 0 && worker.PID != deadWorker.PID {
+				respawnedWorker = &worker
+			}
+		}
+	}
+	if respawnedWorker == nil {
+		t.Fatalf("dead worker %d should be forked again, got: %v", deadWorker.ID, workers)
+	}
+	if respawnedWorker.Restarts != 1 || respawnedWorker.LastSignal != "SIGKILL" {
+		t.Fatalf("respawned worker should report the restart and the signal, got: %+v", respawnedWorker)
+	}
+	if len(workers) != 2 {
+		t.Fatalf("project should still have 2 workers, got: %v", workers)
+	}
+
+	if _, err := StopProject(testProjectPackageName); err != nil {
+		t.Fatalf("could not stopping the project due to error: %s", err)
+	}
+	<-receivingProjStateChan
+}
+
+func TestRestartPolicyBackoff(t *testing.T) {
+	policy := getRestartPolicy(node.RestartPolicy{
+		MinUptime:      1000,
+		MaxRestarts:    4,
+		RestartWindow:  60000,
+		InitialBackoff: 100,
+		MaxBackoff:     300,
+	})
+	tracker := &restartTracker{}
+	now := time.Now()
+	// Unstable runs double the backoff until the max backoff
+	for _, expectedDelay := range []time.Duration{100, 200, 300} {
+		delay, shouldRestart := tracker.nextRestart(policy, 0, now)
+		if !shouldRestart || delay != expectedDelay*time.Millisecond {
+			t.Fatalf("expected restart in %dms, got: %s, %v", expectedDelay, delay, shouldRestart)
+		}
+	}
+	// A stable run resets the backoff
+	if delay, _ := tracker.nextRestart(policy, time.Minute, now); delay != 100*time.Millisecond {
+		t.Fatalf("expected restart in 100ms after a stable run, got: %s", delay)
+	}
+	if _, shouldRestart := tracker.nextRestart(policy, 0, now); shouldRestart {
+		t.Fatal("project should not be restarted after max restarts inside the restart window")
+	}
+	if _, shouldRestart := tracker.nextRestart(policy, 0, now.Add(time.Minute)); !shouldRestart {
+		t.Fatal("restarts outside the restart window should not be counted")
+	}
+}
+
+func TestProjectStatusTransitions(t *testing.T) {
+	projectState := &ProjectState{}
+	for _, status := range []ProjectStatus{StatusStarting, StatusOnline, StatusCrashed, StatusRestarting, StatusStarting, StatusOnline, StatusStopping, StatusStopped} {
+		if err := projectState.SetStatus(status); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := projectState.SetStatus(StatusOnline); err == nil {
+		t.Fatal("stopped project should not be online before starting")
+	}
+}
+
+func TestCrashingProjectIsErrored(t *testing.T) {
+	ClearDB()
+	if err := AddProject(testCrashProjectDirectory); err != nil {
+		t.Fatalf("adding a project to manager error: %s", err)
+	}
+	UpdateProject(testCrashProjectPackageName, []byte(`{"restart_policy": {"max_restarts": 2, "initial_backoff": 10}}`))
+	if err := StartProject(testCrashProjectPackageName, 0, nil); err != nil {
+		t.Fatalf("project is failed to start: %s", err)
+	}
+	var projectState *ProjectState
+	for i := 0; i < 100; i++ {
+		projectState, _ = GetProjectState(testCrashProjectPackageName)
+		if projectState.GetStatus() == StatusErrored {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if projectState.GetStatus() != StatusErrored {
+		t.Fatalf("crashing project should be errored, status: %s", projectState.GetStatus())
+	}
+	if projectState.RestartCount != 2 || projectState.LastExitCode != 1 {
+		t.Fatalf("expected 2 restarts and exit code 1, got: %d restarts, exit code %d", projectState.RestartCount, projectState.LastExitCode)
+	}
+	projectRuns, _ := GetProjectHistory(testCrashProjectPackageName, 0)
+	if len(projectRuns) != 3 || !projectRuns[0].Restarted || projectRuns[2].Restarted {
+		t.Fatalf("expected 3 runs in history and only the last one not restarted, got: %v", projectRuns)
+	}
+}
+
+func TestFailingToAutoRestartAProject(t *testing.T) {
+	ClearDB()
+	AddProject(testCrashProjectDirectory)
+	UpdateProject(testCrashProjectPackageName, []byte(`{"restart_policy": {"initial_backoff": 500}}`))
+	if err := StartProject(testCrashProjectPackageName, 0, nil); err != nil {
+		t.Fatalf("project is failed to start: %s", err)
+	}
+	var projectState *ProjectState
+	for i := 0; i < 100; i++ {
+		if projectState, _ = GetProjectState(testCrashProjectPackageName); projectState.GetStatus() == StatusRestarting {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if projectState.GetStatus() != StatusRestarting {
+		t.Fatalf("crashed project should be restarting, status: %s", projectState.GetStatus())
+	}
+	// The restart fails because the env file is missing
+	UpdateProject(testCrashProjectPackageName, []byte(`{"env_file": "missing.env"}`))
+	for i := 0; i < 100; i++ {
+		if projectState, _ = GetProjectState(testCrashProjectPackageName); projectState.GetStatus() == StatusErrored {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if projectState.GetStatus() != StatusErrored {
+		t.Fatalf("project that failed to auto restart should be errored, status: %s", projectState.GetStatus())
+	}
+	projectRuns, _ := GetProjectHistory(testCrashProjectPackageName, 0)
+	if len(projectRuns) != 1 || projectRuns[0].Restarted {
+		t.Fatalf("expected 1 run in history that is not restarted, got: %v", projectRuns)
+	}
+}
+
+func TestPlanningAnEcosystem(t *testing.T) {
+	ClearDB()
+	AddProject(testCrashProjectDirectory)
+	ecosystem, err := ParseEcosystemFile(filepath.Join(testProjectDirectory, "../ecosystem.yaml"))
+	if err != nil {
+		t.Fatalf("parsing the ecosystem file error: %s", err)
+	}
+	changes, err := PlanEcosystem(ecosystem)
+	if err != nil {
+		t.Fatalf("planning the ecosystem error: %s", err)
+	}
+	if len(changes) != 2 || changes[0].Action != ApplyActionRemove || changes[1].Action != ApplyActionAdd {
+		t.Fatalf("expected to remove the crash project and add the express project, got: %v", changes)
+	}
+
+	AddProject(testProjectDirectory)
+	changes, _ = PlanEcosystem(ecosystem)
+	if changes[1].Action != ApplyActionUpdate || len(changes[1].Diff) != 3 {
+		t.Fatalf("expected to update 3 fields of the express project, got: %v", changes[1])
+	}
+	if len(changes) != 3 || changes[2].Action != ApplyActionStart || changes[2].Project != testProjectPackageName {
+		t.Fatalf("expected to start the stopped express project after updating it, got: %v", changes)
+	}
+}
+
+func TestResolvingProjectEnv(t *testing.T) {
+	workingDir := t.TempDir()
+	envFileContent := "# comment\nexport PORT=3000\nNODE_ENV=development # inline comment\nSECRET=\"multi\\nline\"\nLITERAL='$HOME'\n"
+	if err := os.WriteFile(filepath.Join(workingDir, ".env"), []byte(envFileContent), 0644); err != nil {
+		t.Fatal(err)
+	}
+	project := &node.Project{
+		WorkingDir: workingDir,
+		Env:        map[string]string{"NODE_ENV": "staging"},
+		EnvProfiles: map[string]map[string]string{
+			"production": {"NODE_ENV": "production"},
+		},
+	}
+	expectedEnv := map[string]string{"PORT": "3000", "NODE_ENV": "production", "SECRET": "multi\nline", "LITERAL": "$HOME"}
+	projectEnv, err := resolveProjectEnv(project, "production")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range expectedEnv {
+		if projectEnv[key] != value {
+			t.Fatalf("%s should be %q, got: %q", key, value, projectEnv[key])
+		}
+	}
+	if _, err := resolveProjectEnv(project, "staging"); err == nil {
+		t.Fatal("unknown environment profile should not be resolved")
+	}
+}
+
+func TestSelectingAndClearingAnEnvProfile(t *testing.T) {
+	ClearDB()
+	AddProject(testProjectDirectory)
+	UpdateProject(testProjectPackageName, []byte(`{"env_profiles": {"staging": {"NODE_ENV": "staging"}}}`))
+	if err := SetProjectEnvProfile(testProjectPackageName, "production"); err == nil {
+		t.Fatal("unknown environment profile should not be selected")
+	}
+	SetProjectEnvProfile(testProjectPackageName, "staging")
+	if projectModel, _ := GetProject(testProjectPackageName); projectModel.EnvProfile != "staging" {
+		t.Fatalf("environment profile should be saved, got: %q", projectModel.EnvProfile)
+	}
+	if err := SetProjectEnvProfile(testProjectPackageName, ""); err != nil {
+		t.Fatalf("environment profile should be cleared: %s", err)
+	}
+	if projectModel, _ := GetProject(testProjectPackageName); projectModel.EnvProfile != "" {
+		t.Fatalf("environment profile should be cleared, got: %q", projectModel.EnvProfile)
+	}
+}
+
+func TestUpdatingOnlyTheEditableProjectFields(t *testing.T) {
+	ClearDB()
+	AddProject(testProjectDirectory)
+	originalProject, _ := GetProject(testProjectPackageName)
+	for _, projectConfig := range []string{
+		`{"working_dir": "/tmp"}`,
+		`{"package": {"main": "other.js"}}`,
+		`{"name": "other-project"}`,
+		`{"kill_timeout": 500, "cluster_processes": 4}`,
+	} {
+		if _, err := UpdateProject(testProjectPackageName, []byte(projectConfig)); err == nil {
+			t.Fatalf("%s should not be updated", projectConfig)
+		}
+	}
+	projectModel, _ := GetProject(testProjectPackageName)
+	if projectModel.WorkingDir != originalProject.WorkingDir || projectModel.Package.Main != originalProject.Package.Main || projectModel.KillTimeout != 0 || projectModel.ClusterProcesses != 0 {
+		t.Fatalf("project should not be changed by the rejected updates, got: %+v", projectModel)
+	}
+	if projectModel, err := UpdateProject(testProjectPackageName, []byte(`{"stop_signal": "SIGINT", "log_rotation": {"max_files": 3}}`)); err != nil {
+		t.Fatalf("editable project fields should be updated: %s", err)
+	} else if projectModel.StopSignal != "SIGINT" || projectModel.LogRotation.MaxFiles != 3 {
+		t.Fatalf("editable project fields should be updated, got: %+v", projectModel)
+	}
+}
+
+func TestReplacingTheProjectEnvByAnUpdate(t *testing.T) {
+	ClearDB()
+	AddProject(testProjectDirectory)
+	UpdateProject(testProjectPackageName, []byte(`{"env": {"NODE_ENV": "staging", "DEBUG": "express:*"}, "log_rotation": {"max_files": 3}}`))
+	projectModel, err := UpdateProject(testProjectPackageName, []byte(`{"env": {"NODE_ENV": "production"}, "log_rotation": {"compress": true}}`))
+	if err != nil {
+		t.Fatalf("updating the project error: %s", err)
+	}
+	if len(projectModel.Env) != 1 || projectModel.Env["NODE_ENV"] != "production" {
+		t.Fatalf("env should be replaced and the DEBUG key removed, got: %v", projectModel.Env)
+	}
+	if projectModel.LogRotation.MaxFiles != 3 || !projectModel.LogRotation.Compress {
+		t.Fatalf("log rotation settings should be merged, got: %+v", projectModel.LogRotation)
+	}
+	UpdateProject(testProjectPackageName, []byte(`{"env": {}}`))
+	if projectModel, _ = GetProject(testProjectPackageName); len(projectModel.Env) != 0 || projectModel.Name != testProjectPackageName {
+		t.Fatalf("env should be cleared and the other fields kept, got: %+v", projectModel)
+	}
+}
+
+func TestGettingAStartScriptCommand(t *testing.T) {
+	project := &node.Project{
+		WorkingDir: testProjectDirectory,
+		Package:    node.Package{Scripts: map[string]string{"start": "node --require dotenv/config 'dist/server.js' --port 80"}},
+		Args:       []string{"--verbose"},
+		NodeArgs:   []string{"--enable-source-maps"},
+	}
+	if project.GetStartMode() != node.StartModeScript {
+		t.Fatalf("project without main script should use the start script, got: %s", project.GetStartMode())
+	}
+	args, err := getProjectCommand(project, defaultInterpreter, 0)
+	expectedArgs := []string{"node", "--enable-source-maps", "--require", "dotenv/config", "dist/server.js", "--port", "80", "--verbose"}
+	if err != nil || strings.Join(args, " ") != strings.Join(expectedArgs, " ") {
+		t.Fatalf("expected command %v, got: %v (%v)", expectedArgs, args, err)
+	}
+
+	project.Package.Scripts["start"] = "NODE_ENV=production node dist/server.js"
+	args, err = getProjectCommand(project, defaultInterpreter, 0)
+	if err != nil || args[0] != "sh" || args[2] != `NODE_ENV=production node dist/server.js "$@"` {
+		t.Fatalf("expected the start script to run by the shell, got: %v (%v)", args, err)
+	}
+	if _, err = getProjectCommand(project, defaultInterpreter, 2); err == nil {
+		t.Fatal("cluster mode should fail for shell start scripts")
+	}
+}
+
+func TestRunningANonNodeProject(t *testing.T) {
+	ClearDB()
+	workingDir := t.TempDir()
+	os.WriteFile(filepath.Join(workingDir, "worker.sh"), []byte("sleep 10\n"), 0644)
+	project := &node.Project{WorkingDir: workingDir, Interpreter: "sh", Script: "worker.sh"}
+	if _, err := RegisterProject(project); err == nil {
+		t.Fatal("projects without package should have a name")
+	}
+	project.Name = "shell-worker"
+	if _, err := RegisterProject(project); err != nil {
+		t.Fatalf("adding a project to manager error: %s", err)
+	}
+	procStateChannel := make(chan *ProjectState)
+	if err := StartProject("shell-worker", 0, procStateChannel); err != nil {
+		t.Fatalf("project is failed to start: %s", err)
+	}
+	if projectState := <-procStateChannel; projectState.GetStatus() != StatusOnline {
+		t.Fatalf("project should be online, status: %s", projectState.GetStatus())
+	}
+	if _, err := StopProject("shell-worker"); err != nil {
+		t.Fatalf("project is failed to stop: %s", err)
+	}
+	if projectState := <-procStateChannel; projectState.IsRunning() {
+		t.Fatal("project is not stopped")
+	}
+}
+
+func TestResolvingProjectNodeVersion(t *testing.T) {
+	versionsDir := t.TempDir()
+	t.Setenv(nodeVersionsDirEnv, versionsDir)
+	for _, version := range []string{"v16.20.2", "v18.17.1", "v18.19.0", "v21.1.0", "v24.1.0"} {
+		os.MkdirAll(filepath.Join(versionsDir, version, "bin"), 0755)
+		os.WriteFile(filepath.Join(versionsDir, version, "bin", "node"), nil, 0755)
+	}
+	expectedVersions := map[string]string{
+		"18":                  "v18.19.0",
+		"^18.17.0":            "v18.19.0",
+		"~18.17":              "v18.17.1",
+		">=16 <18":            "v16.20.2",
+		"16 - 18.17":          "v18.17.1",
+		"14 || 16":            "v16.20.2",
+		"lts/*":               "v18.19.0",
+		"lts/gallium":         "v16.20.2",
+		"node":                "v24.1.0",
+		">= 19.0.0-rc.1 < 22": "v21.1.0",
+	}
+	workingDir := t.TempDir()
+	for versionRange, expectedVersion := range expectedVersions {
+		os.WriteFile(filepath.Join(workingDir, ".nvmrc"), []byte(versionRange+"\n"), 0644)
+		nodePath, err := getProjectNodePath(&node.Project{WorkingDir: workingDir})
+		if err != nil || nodePath != filepath.Join(versionsDir, expectedVersion, "bin", "node") {
+			t.Fatalf("expected node %s for %s, got: %s (%v)", expectedVersion, versionRange, nodePath, err)
+		}
+	}
+
+	os.Remove(filepath.Join(workingDir, ".nvmrc"))
+	project := &node.Project{WorkingDir: workingDir, Package: node.Package{Engines: map[string]string{"node": ">=25"}}}
+	if nodePath, _ := getProjectNodePath(project); nodePath != defaultInterpreter {
+		t.Fatalf("projects with unsatisfied engines.node should use the default node, got: %s", nodePath)
+	}
+	if checkNodeEngine(project) == "" {
+		t.Fatal("unsatisfied engines.node should be warned")
+	}
+	t.Setenv(nodeVersionsDirEnv, t.TempDir())
+	if warning := checkNodeEngine(project); warning != "" {
+		t.Fatalf("engines.node should not be warned without installed node versions, got: %s", warning)
+	}
+	t.Setenv(nodeVersionsDirEnv, versionsDir)
+	project.NodeVersion = "20"
+	if _, err := getProjectNodePath(project); err == nil {
+		t.Fatal("projects with node version that is not installed should fail")
+	}
+}
+
+func TestRunningAModuleClusterModeProject(t *testing.T) {
+	ClearDB()
+	workingDir := t.TempDir()
+	// Modules with top level await can't be required
+	os.WriteFile(filepath.Join(workingDir, "package.json"), []byte(`{"name": "module-example-project", "type": "module", "main": "index.js"}`), 0644)
+	os.WriteFile(filepath.Join(workingDir, "index.js"), []byte("import http from 'http';\nawait Promise.resolve();\nhttp.createServer((req, res) => res.end()).listen(0);\n"), 0644)
+	if err := AddProject(workingDir); err != nil {
+		t.Fatalf("adding a project to manager error: %s", err)
+	}
+	receivingProjStateChan := make(chan *ProjectState)
+	defer close(receivingProjStateChan)
+	if err := StartProject("module-example-project", 2, receivingProjStateChan); err != nil {
+		t.Fatalf("project is failed to start: %s", err)
+	}
+	<-receivingProjStateChan
+	clusterModeScriptPath := filepath.Join(getProjectRuntimeDir("module-example-project"), ClusterModeScript)
+	if _, err := os.Stat(clusterModeScriptPath); err != nil {
+		t.Fatalf("cluster mode script should be created in the runtime dir: %s", err)
+	}
+	// Reloading waits for the new workers to listen, it fails if the workers can't import the script
+	if err := ReloadProject("module-example-project"); err != nil {
+		t.Fatalf("project is failed to reload: %s", err)
+	}
+	if _, err := StopProject("module-example-project"); err != nil {
+		t.Fatalf("could not stopping the project due to error: %s", err)
+	}
+	<-receivingProjStateChan
+	if _, err := os.Stat(clusterModeScriptPath); !os.IsNotExist(err) {
+		t.Fatal("cluster mode script should be removed when the project is stopped")
+	}
+	if files, _ := filepath.Glob(filepath.Join(workingDir, "*")); len(files) != 2 {
+		t.Fatalf("the working dir should not be changed, got: %v", files)
+	}
+}
+
+func TestCreatingAndRevokingTokens(t *testing.T) {
+	secret, err := CreateToken("ci")
+	if err != nil {
+		t.Fatalf("Failed to create a token, error: %s", err)
+	}
+	defer RevokeToken("ci")
+	if _, err := CreateToken("ci"); err == nil {
+		t.Fatal("tokens with the same name should fail")
+	}
+	if token, ok := VerifyToken(secret); !ok || token.Name != "ci" {
+		t.Fatal("the token secret should be verified")
+	}
+	if _, ok := VerifyToken(secret + "x"); ok {
+		t.Fatal("a wrong token secret should not be verified")
+	}
+	tokens, _ := GetTokens()
+	if len(tokens) != 1 || tokens[0].Hash != "" {
+		t.Fatalf("expected one token without its hash, got: %v", tokens)
+	}
+	if err := RevokeToken("ci"); err != nil {
+		t.Fatalf("Failed to revoke the token, error: %s", err)
+	}
+	if _, ok := VerifyToken(secret); ok {
+		t.Fatal("a revoked token should not be verified")
+	}
+}
+
+func TestRotatingAProjectLog(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "app.log")
+	rotation := getLogRotation(node.LogRotation{MaxSize: 100, MaxFiles: 2, Compress: true})
+	writer, err := openLogWriter(logPath, rotation, false)
+	if err != nil {
+		t.Fatalf("Failed to open the log writer, error: %s", err)
+	}
+	for i := 0; i < 10; i++ {
+		writer.Write([]byte(strings.Repeat("x", 59) + "\n"))
+	}
+	writer.Close()
+	var rotatedLogs []rotatedLog
+	for i := 0; i < 50; i++ {
+		rotatedLogs = getRotatedLogs(logPath)
+		if len(rotatedLogs) == 2 && rotatedLogs[0].compressed && rotatedLogs[1].compressed {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if len(rotatedLogs) != 2 || !rotatedLogs[0].compressed || !rotatedLogs[1].compressed {
+		t.Fatalf("expected 2 compressed rotated logs, got: %v", rotatedLogs)
+	}
+	// The newest rotated files are kept, the files of the same second are ordered by their index
+	if logFiles, _ := filepath.Glob(logPath[:len(logPath)-4] + "-*"); len(logFiles) != 2 {
+		t.Fatalf("expected only the files of 2 rotated logs, got: %v", logFiles)
+	}
+	if rotatedLogs[0].time.Equal(rotatedLogs[1].time) && rotatedLogs[0].index >= rotatedLogs[1].index {
+		t.Fatalf("rotated logs are not ordered by their index: %v", rotatedLogs)
+	}
+
+	rotatedDir := t.TempDir()
+	rotatedPath := filepath.Join(rotatedDir, "app.log")
+	for _, name := range []string{"app-20240102-150405.10.log.gz", "app-20240102-150405.2.log", "app-20240102-150405.log.gz",
+		"app-20240101-235959.log", "app-20240102-150405.2.log.gz", "app-20240102-160000.log"} {
+		os.WriteFile(filepath.Join(rotatedDir, name), nil, 0640)
+	}
+	expectedOrder := []string{"app-20240101-235959.log", "app-20240102-150405.log", "app-20240102-150405.2.log",
+		"app-20240102-150405.10.log", "app-20240102-160000.log"}
+	rotatedLogs = getRotatedLogs(rotatedPath)
+	if len(rotatedLogs) != len(expectedOrder) {
+		t.Fatalf("expected %d rotated logs, got: %v", len(expectedOrder), rotatedLogs)
+	}
+	for i, rotated := range rotatedLogs {
+		if filepath.Base(rotated.path) != expectedOrder[i] {
+			t.Fatalf("expected rotated log %s at %d, got: %v", expectedOrder[i], i, rotatedLogs)
+		}
+	}
+	if rotatedLogs[2].compressed {
+		t.Fatal("rotated log that is being compressed should not be compressed")
+	}
+	pruneRotatedLogs(rotatedPath, 2)
+	if logFiles, _ := filepath.Glob(filepath.Join(rotatedDir, "app-*")); len(logFiles) != 2 {
+		t.Fatalf("expected the files of the 2 newest rotated logs, got: %v", logFiles)
+	}
+
+	// The log is appended when it is opened again
+	writer, _ = openLogWriter(logPath, rotation, false)
+	writer.Write([]byte("appended\n"))
+	writer.Close()
+	if logBytes, _ := os.ReadFile(logPath); string(logBytes) != strings.Repeat("x", 59)+"\nappended\n" {
+		t.Fatalf("log should be appended, got: %q", logBytes)
+	}
+
+	// A log of a previous day is rotated when it is opened
+	yesterday := time.Now().AddDate(0, 0, -1)
+	os.Chtimes(logPath, yesterday, yesterday)
+	writer, _ = openLogWriter(logPath, getLogRotation(node.LogRotation{Interval: LogIntervalDaily}), false)
+	writer.Close()
+	if logInfo, _ := os.Stat(logPath); logInfo.Size() != 0 {
+		t.Fatal("log of a previous day should be rotated")
+	}
+}
+
+func TestMergingProjectLogs(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "app.log")
+	dateFormat := "2006-01-02 15:04:05 MST"
+	writer, _ := openLogWriter(logPath, getLogRotation(node.LogRotation{}), true)
+	writer.Write([]byte("first "))
+	writer.Write([]byte("line\nsecond: line\n"))
+	writer.Close()
+	logLines, _, _ := readLogLines(logPath, LogStreamOut, 0, dateFormat, nil)
+	if len(logLines) != 2 || logLines[0].Time == nil || logLines[1].Time == nil {
+		t.Fatalf("expected 2 timestamped lines, got: %v", logLines)
+	}
+	// The timestamp is printed in the date format, a date format with the separator doesn't change the line
+	if expectedText := logLines[1].Time.Local().Format(dateFormat) + ": second: line"; logLines[1].Text != expectedText {
+		t.Fatalf("expected line %q, got: %q", expectedText, logLines[1].Text)
+	}
+	if logLines, _, _ = readLogLines(logPath, LogStreamOut, 0, "", nil); logLines[0].Text != "first line" {
+		t.Fatalf("log line timestamp should be removed without a date format, got: %q", logLines[0].Text)
+	}
+
+	lineTime := func(second int) *time.Time {
+		lineTime := time.Date(2024, 1, 1, 0, 0, second, 0, time.UTC)
+		return &lineTime
+	}
+	outLines := []LogLine{
+		{Stream: LogStreamOut, Text: "out 1", Time: lineTime(1)},
+		{Stream: LogStreamOut, Text: "out 2", Time: lineTime(3)},
+	}
+	errLines := []LogLine{
+		{Stream: LogStreamErr, Text: "err 1", Time: lineTime(2)},
+		{Stream: LogStreamErr, Text: "    at stack trace"},
+	}
+	expectedLines := []LogLine{outLines[0], errLines[0], errLines[1], outLines[1]}
+	mergedLines := mergeLogLines(outLines, errLines)
+	if fmt.Sprint(mergedLines) != fmt.Sprint(expectedLines) {
+		t.Fatalf("log lines are not merged by time, got: %v", mergedLines)
+	}
+	// Logs without timestamps are merged in file order
+	outLines = []LogLine{{Stream: LogStreamOut, Text: "out 1"}, {Stream: LogStreamOut, Text: "out 2"}}
+	errLines = []LogLine{{Stream: LogStreamErr, Text: "err 1"}}
+	expectedLines = []LogLine{outLines[0], outLines[1], errLines[0]}
+	if mergedLines = mergeLogLines(outLines, errLines); fmt.Sprint(mergedLines) != fmt.Sprint(expectedLines) {
+		t.Fatalf("log lines without timestamps should be merged in file order, got: %v", mergedLines)
+	}
+}
+
+func TestReadingLastLogLines(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "app.log")
+	dateFormat := "2006-01-02T15:04:05"
+	var logContent strings.Builder
+	for i := 0; i < 20000; i++ {
+		lineTime := time.Date(2024, 1, 1, i/3600, i/60%60, i%60, 0, time.Local)
+		fmt.Fprintf(&logContent, "%s line %d\n", lineTime.Format(logLineTimeLayout), i)
+	}
+	logContent.WriteString("    at stack trace\n")
+	os.WriteFile(logPath, []byte(logContent.String()), 0640)
+
+	logLines, offset, _ := readLogLines(logPath, LogStreamOut, 3, dateFormat, nil)
+	if offset != int64(logContent.Len()) {
+		t.Fatalf("expected offset %d, got: %d", logContent.Len(), offset)
+	}
+	if len(logLines) != 3 || !strings.HasSuffix(logLines[0].Text, ": line 19998") || logLines[2].Text != "    at stack trace" {
+		t.Fatalf("expected the last 3 lines, got: %v", logLines)
+	}
+	allLines, _, _ := readLogLines(logPath, LogStreamOut, 0, dateFormat, nil)
+	if len(allLines) != 20001 || !strings.HasSuffix(allLines[0].Text, ": line 0") {
+		t.Fatalf("expected all the 20001 lines, got: %d", len(allLines))
+	}
+
+	since, _ := ParseLogTime("2024-01-01 05:00:00", time.Now())
+	until, _ := ParseLogTime("2024-01-01T05:00:02", time.Now())
+	filter := &LogFilter{Since: since, Until: until}
+	logLines, _, _ = readLogLines(logPath, LogStreamOut, 0, dateFormat, filter)
+	if len(logLines) != 3 || !strings.HasSuffix(logLines[0].Text, ": line 18000") {
+		t.Fatalf("expected the lines between since and until, got: %v", logLines)
+	}
+	filter = &LogFilter{Since: until}
+	logLines, _, _ = readLogLines(logPath, LogStreamOut, 0, dateFormat, filter)
+	if len(logLines) != 1999 || logLines[len(logLines)-1].Text != "    at stack trace" {
+		t.Fatalf("expected the lines since the time with the stack trace, got: %d", len(logLines))
+	}
+	filter = &LogFilter{Pattern: regexp.MustCompile(`line 1\d{3}$`)}
+	logLines, _, _ = readLogLines(logPath, LogStreamOut, 2, dateFormat, filter)
+	if len(logLines) != 2 || !strings.HasSuffix(logLines[1].Text, ": line 1999") {
+		t.Fatalf("expected the last 2 matching lines, got: %v", logLines)
+	}
+	if _, err := ParseLogTime("yesterday", time.Now()); err == nil {
+		t.Fatalf("invalid log time should not be parsed")
+	}
+
+	oversizedLine := time.Now().Format(logLineTimeLayout) + " " + strings.Repeat("x", 3*maxLogLineSize)
+	os.WriteFile(logPath, []byte("first line\n"+oversizedLine+"\nlast line\n"), 0640)
+	logLines, _, err := readLogLines(logPath, LogStreamOut, 0, "", nil)
+	if err != nil {
+		t.Fatalf("oversized log line should not fail the query: %s", err)
+	}
+	if len(logLines) != 3 || logLines[0].Text != "first line" || logLines[2].Text != "last line" {
+		t.Fatalf("expected the lines around the oversized line, got: %d lines", len(logLines))
+	}
+	if logLines[1].Time == nil || logLines[1].Text != oversizedLine[len(logLineTimeLayout)+1:maxLogLineSize] {
+		t.Fatalf("oversized log line should be truncated to its start, got: %d bytes", len(logLines[1].Text))
+	}
+}
+
+func TestFollowingAProjectLogAcrossRotationsAndRestarts(t *testing.T) {
+	ClearDB()
+	projectDirectory := t.TempDir()
+	logPath := filepath.Join(t.TempDir(), "app.log")
+	os.WriteFile(filepath.Join(projectDirectory, "package.json"), []byte(`{"name": "counter-example-project", "main": "index.js"}`), 0640)
+	os.WriteFile(filepath.Join(projectDirectory, "index.js"), []byte("let i = 0;\nsetInterval(function() { console.log(process.pid + ' line ' + i++); }, 2);\n"), 0640)
+	AddProject(projectDirectory)
+	UpdateProject("counter-example-project", []byte(fmt.Sprintf(`{"log_path": %q, "log_rotation": {"max_size": 2000, "max_files": 1000}}`, logPath)))
+
+	done := make(chan struct{})
+	logLines, err := FollowProjectLogs("counter-example-project", LogStreamOut, 0, nil, done)
+	if err != nil {
+		t.Fatalf("following the project log error: %s", err)
+	}
+	followedLines := make([]string, 0)
+	followed := make(chan struct{})
+	go func() {
+		for logLine := range logLines {
+			followedLines = append(followedLines, logLine.Text)
+		}
+		close(followed)
+	}()
+	receivingProjStateChan := make(chan *ProjectState, 10)
+	if err := StartProject("counter-example-project", 0, receivingProjStateChan); err != nil {
+		t.Fatalf("project is failed to start: %s", err)
+	}
+	time.Sleep(time.Second)
+	if err := RestartProject("counter-example-project", receivingProjStateChan); err != nil {
+		t.Fatalf("project is failed to restart: %s", err)
+	}
+	time.Sleep(time.Second)
+	StopProject("counter-example-project")
+	// The follower reads the lines that are written before the stop
+	time.Sleep(500 * time.Millisecond)
+	close(done)
+	<-followed
+
+	writtenLines := make([]string, 0)
+	for _, rotatedLogFile := range getRotatedLogs(logPath) {
+		rotatedLogLines, _, _ := readLogLines(rotatedLogFile.path, LogStreamOut, 0, "", nil)
+		for _, logLine := range rotatedLogLines {
+			writtenLines = append(writtenLines, logLine.Text)
+		}
+	}
+	currentLogLines, _, _ := readLogLines(logPath, LogStreamOut, 0, "", nil)
+	for _, logLine := range currentLogLines {
+		writtenLines = append(writtenLines, logLine.Text)
+	}
+	if len(getRotatedLogs(logPath)) < 2 {
+		t.Fatalf("expected the log to be rotated while it is followed")
+	}
+	if projectRuns, _ := GetProjectHistory("counter-example-project", 0); len(projectRuns) != 2 {
+		t.Fatalf("expected 2 runs of the followed project, got: %v", projectRuns)
+	}
+	if strings.Join(followedLines, "\n") != strings.Join(writtenLines, "\n") {
+		t.Fatalf("expected the %d written lines to be followed once, got %d lines", len(writtenLines), len(followedLines))
+	}
+}
+
+func TestQueryingStructuredLogLines(t *testing.T) {
+	dateFormat := "2006-01-02T15:04:05"
+	pinoLine := newLogLine(LogStreamOut, `2024-01-01T00:00:01.000000+00:00 {"level":40,"time":1704067200000,"msg":"slow request","reqId":"abc","req":{"method":"GET"},"ms":1200}`, dateFormat)
+	bunyanLine := newLogLine(LogStreamOut, `{"name":"app","level":30,"time":"2024-01-01T00:00:02Z","msg":"started","v":0}`, dateFormat)
+	textLine := newLogLine(LogStreamOut, "2024-01-01T00:00:03.000000+00:00 plain text", dateFormat)
+	if pinoLine.Entry == nil || pinoLine.Entry.Level != "warn" || pinoLine.Entry.Message != "slow request" || pinoLine.Entry.Time.UnixMilli() != 1704067200000 {
+		t.Fatalf("pino line is not parsed, got: %+v", pinoLine.Entry)
+	}
+	if bunyanLine.Entry == nil || bunyanLine.Entry.Level != "info" || bunyanLine.Entry.Fields["name"] != "app" {
+		t.Fatalf("bunyan line is not parsed, got: %+v", bunyanLine.Entry)
+	}
+	if textLine.Entry != nil {
+		t.Fatalf("text line should not be parsed, got: %+v", textLine.Entry)
+	}
+
+	expectedMatches := map[string][]bool{
+		"level>=warn":    {true, false, false},
+		"level=info":     {false, true, false},
+		"reqId=abc":      {true, false, false},
+		"reqId!=abc":     {false, false, false},
+		"req.method=GET": {true, false, false},
+		"ms>1000":        {true, false, false},
+		"name=app":       {false, true, false},
+		"level<=30":      {false, true, false},
+		"missing!=value": {false, false, false},
+	}
+	for expression, expected := range expectedMatches {
+		condition, err := ParseLogCondition(expression)
+		if err != nil {
+			t.Fatalf("log condition %s should be parsed: %s", expression, err)
+		}
+		for i, logLine := range []LogLine{pinoLine, bunyanLine, textLine} {
+			if condition.match(logLine.Entry) != expected[i] {
+				t.Fatalf("log condition %s of line %d should be %t", expression, i, expected[i])
+			}
+		}
+	}
+	for _, expression := range []string{"level", "=abc", "level>=loud"} {
+		if _, err := ParseLogCondition(expression); err == nil {
+			t.Fatalf("invalid log condition %s should not be parsed", expression)
+		}
+	}
+}
+
+func TestRecoveringProjectStuckInATransientState(t *testing.T) {
+	expectedStatuses := map[string]ProjectStatus{
+		"stuck-starting-project":   StatusStopped,
+		"stuck-restarting-project": StatusStopped,
+		"stuck-crashed-project":    StatusErrored,
+	}
+	SaveProjectState("stuck-starting-project", &ProjectState{Status: StatusStarting})
+	SaveProjectState("stuck-restarting-project", &ProjectState{Status: StatusRestarting})
+	SaveProjectState("stuck-crashed-project", &ProjectState{Status: StatusCrashed, PID: 999999})
+	recoverProjectStates()
+	for packageName, expectedStatus := range expectedStatuses {
+		projectState, _ := GetProjectState(packageName)
+		if projectState.GetStatus() != expectedStatus || projectState.IsRunning() {
+			t.Fatalf("expected %s to be %s, got: %s", packageName, expectedStatus, projectState.GetStatus())
+		}
+		if err := projectState.SetStatus(StatusStarting); err != nil {
+			t.Fatalf("%s should be started after it is recovered: %s", packageName, err)
+		}
+		db.Delete([]byte(statePrefixKey+packageName), nil)
+	}
+}
@@ -0,0 +1,201 @@
+package manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/eladyarkoni/bpm/node"
+)
+
+const (
+	// nodeVersionsDirEnv the environment variable of the installed node versions directory
+	nodeVersionsDirEnv = "BPM_NODE_VERSIONS_DIR"
+	// nvmDirEnv the nvm directory environment variable, nvm node versions are used by default
+	nvmDirEnv = "NVM_DIR"
+)
+
+// nodeVersionFiles the files that pin the node version of the project, in the order they are checked
+var nodeVersionFiles = []string{".nvmrc", ".node-version"}
+
+// ltsCodenames the major versions of the node lts codenames, lts/* matches only these versions
+var ltsCodenames = map[string]int{
+	"argon":    4,
+	"boron":    6,
+	"carbon":   8,
+	"dubnium":  10,
+	"erbium":   12,
+	"fermium":  14,
+	"gallium":  16,
+	"hydrogen": 18,
+	"iron":     20,
+	"jod":      22,
+}
+
+// installedNodeVersion a node version of the node versions directory
+type installedNodeVersion struct {
+	version node.Version
+	path    string
+}
+
+// getNodeVersionsDir gets the directory of the installed node versions
+//
+// Every version is installed in a sub directory named by the version (v18.17.1/bin/node),
+// like the nvm versions directory
+func getNodeVersionsDir() string {
+	if versionsDir := os.Getenv(nodeVersionsDirEnv); versionsDir != "" {
+		return versionsDir
+	}
+	nvmDir := os.Getenv(nvmDirEnv)
+	if nvmDir == "" {
+		homeDir, _ := os.UserHomeDir()
+		nvmDir = filepath.Join(homeDir, ".nvm")
+	}
+	return filepath.Join(nvmDir, "versions", "node")
+}
+
+// getInstalledNodeVersions gets the installed node versions, the latest version first
+func getInstalledNodeVersions() []installedNodeVersion {
+	versionsDir := getNodeVersionsDir()
+	versionDirs, _ := ioutil.ReadDir(versionsDir)
+	versions := make([]installedNodeVersion, 0)
+	for _, versionDir := range versionDirs {
+		version, err := node.ParseVersion(versionDir.Name())
+		if err != nil {
+			continue
+		}
+		nodePath := filepath.Join(versionsDir, versionDir.Name(), "bin", "node")
+		if _, err := os.Stat(nodePath); err != nil {
+			continue
+		}
+		versions = append(versions, installedNodeVersion{version: version, path: nodePath})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].version.Compare(versions[j].version) > 0
+	})
+	return versions
+}
+
+// findNodeVersion finds the latest installed node version that satisfies the version range
+//
+// nvm aliases are supported: node, stable, lts/* and lts/<codename>
+func findNodeVersion(versions []installedNodeVersion, versionRange string) (*installedNodeVersion, error) {
+	versionRange = strings.TrimSpace(versionRange)
+	switch alias := strings.ToLower(versionRange); {
+	case alias == "node" || alias == "stable" || alias == "latest" || alias == "current":
+		versionRange = "*"
+	case alias == "lts/*":
+		versionRange = getLTSVersionRange()
+	case strings.HasPrefix(alias, "lts/"):
+		major, ok := ltsCodenames[strings.TrimPrefix(alias, "lts/")]
+		if !ok {
+			return nil, fmt.Errorf("unknown node lts version %s", versionRange)
+		}
+		versionRange = fmt.Sprintf("%d", major)
+	}
+	for i := range versions {
+		matched, err := node.MatchVersion(versions[i].version, versionRange)
+		if err != nil {
+			return nil, fmt.Errorf("invalid node version %s", versionRange)
+		}
+		if matched {
+			return &versions[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// getLTSVersionRange gets the version range of all the lts major versions of the lts codenames
+//
+// A new even major version is not matched before it becomes an lts version
+func getLTSVersionRange() string {
+	majors := make([]int, 0, len(ltsCodenames))
+	for _, major := range ltsCodenames {
+		majors = append(majors, major)
+	}
+	sort.Ints(majors)
+	versionRanges := make([]string, len(majors))
+	for i, major := range majors {
+		versionRanges[i] = fmt.Sprintf("%d", major)
+	}
+	return strings.Join(versionRanges, " || ")
+}
+
+// getProjectNodeVersion gets the node version of the project and where it is defined
+//
+// The version is taken from the project node_version, the .nvmrc or .node-version files
+// of the working directory or the engines.node field of the package
+func getProjectNodeVersion(project *node.Project) (string, string) {
+	if project.NodeVersion != "" {
+		return project.NodeVersion, "node_version"
+	}
+	for _, versionFile := range nodeVersionFiles {
+		fileBytes, err := ioutil.ReadFile(filepath.Join(project.WorkingDir, versionFile))
+		if err != nil {
+			continue
+		}
+		if version := strings.TrimSpace(strings.SplitN(string(fileBytes), "\n", 2)[0]); version != "" {
+			return version, versionFile
+		}
+	}
+	if version := project.Package.GetNodeEngine(); version != "" {
+		return version, "engines.node"
+	}
+	return "", ""
+}
+
+// getProjectNodePath gets the node binary that runs the project
+//
+// The node path of the project is used if it is defined, otherwise the latest installed node
+// version that satisfies the project node version. If there is no node version or no installed
+// versions, the node of the server PATH is used.
+// If the node version is not installed, projects that pin their version fail to start, projects
+// that only define engines.node run with the node of the server PATH.
+func getProjectNodePath(project *node.Project) (string, error) {
+	if project.NodePath != "" {
+		return project.NodePath, nil
+	}
+	versionRange, source := getProjectNodeVersion(project)
+	if versionRange == "" {
+		return defaultInterpreter, nil
+	}
+	versions := getInstalledNodeVersions()
+	if len(versions) == 0 {
+		return defaultInterpreter, nil
+	}
+	version, err := findNodeVersion(versions, versionRange)
+	if err != nil {
+		return "", err
+	}
+	if version == nil {
+		if source == "engines.node" {
+			return defaultInterpreter, nil
+		}
+		return "", fmt.Errorf("node version %s (%s) is not installed in %s", versionRange, source, getNodeVersionsDir())
+	}
+	return version.path, nil
+}
+
+// checkNodeEngine gets a warning if no installed node version satisfies the engines.node of the package
+func checkNodeEngine(project *node.Project) string {
+	nodeEngine := project.Package.GetNodeEngine()
+	if nodeEngine == "" || project.NodePath != "" {
+		return ""
+	}
+	versions := getInstalledNodeVersions()
+	// Without installed versions the node of the server PATH is used and its version is not known
+	if len(versions) == 0 {
+		return ""
+	}
+	version, err := findNodeVersion(versions, nodeEngine)
+	if err != nil {
+		return fmt.Sprintf("engines.node %s is invalid", nodeEngine)
+	}
+	if version == nil {
+		return fmt.Sprintf("no installed node version satisfies engines.node %s (%s)", nodeEngine, getNodeVersionsDir())
+	}
+	return ""
+}
@@ -0,0 +1,155 @@
+package manager
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// homeEnv the environment variable of the bpm home directory
+const homeEnv = "BPM_HOME"
+
+// legacyDBPath the database path of the versions that kept the manager files in /tmp
+var legacyDBPath = "/tmp/bulk-pm.db"
+
+// Paths the layout of the bpm home directory
+//
+// The home directory holds the database, the project and server logs, the runtime files of
+// the running projects, the server pid file, socket and configuration file and the server
+// contexts of the command line
+type Paths struct {
+	Home          string
+	DB            string
+	LogsDir       string
+	ServerLogFile string
+	RunDir        string
+	PIDFile       string
+	Socket        string
+	ConfigFile    string
+	ContextsFile  string
+}
+
+// paths the layout that is used by the manager
+var paths = NewPaths(GetHomeDir())
+
+// GetHomeDir gets the bpm home directory
+//
+// BPM_HOME is used if it is defined, otherwise the bpm directory of the user data
+// directory ($XDG_DATA_HOME/bpm or ~/.local/share/bpm)
+func GetHomeDir() string {
+	if homeDir := os.Getenv(homeEnv); homeDir != "" {
+		return homeDir
+	}
+	dataDir := os.Getenv("XDG_DATA_HOME")
+	if dataDir == "" {
+		userHomeDir, _ := os.UserHomeDir()
+		dataDir = filepath.Join(userHomeDir, ".local", "share")
+	}
+	return filepath.Join(dataDir, "bpm")
+}
+
+// NewPaths gets the layout of the home directory
+func NewPaths(homeDir string) *Paths {
+	return &Paths{
+		Home:          homeDir,
+		DB:            filepath.Join(homeDir, "db"),
+		LogsDir:       filepath.Join(homeDir, "logs"),
+		ServerLogFile: filepath.Join(homeDir, "logs", "bpm.log"),
+		RunDir:        filepath.Join(homeDir, "run"),
+		PIDFile:       filepath.Join(homeDir, "bpm.pid"),
+		Socket:        filepath.Join(homeDir, "bpm.sock"),
+		ConfigFile:    filepath.Join(homeDir, "config.yaml"),
+		ContextsFile:  filepath.Join(homeDir, "contexts.json"),
+	}
+}
+
+// GetPaths gets the layout of the home directory that is used by the manager
+func GetPaths() *Paths {
+	return paths
+}
+
+// SetPaths sets the layout of the home directory, it must be called before Init
+func SetPaths(layout *Paths) {
+	paths = layout
+}
+
+// CreateDirs creates the directories of the home directory
+func (layout *Paths) CreateDirs() error {
+	for _, dir := range []string{layout.Home, layout.LogsDir, layout.RunDir} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateLegacyDB copies the database of the legacy location to the database path, if there is
+// no database in the database path yet
+//
+// Returns true if the database is migrated, the legacy database is kept
+func migrateLegacyDB(legacyPath string, dbPath string) (bool, error) {
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		return false, err
+	}
+	legacyFiles, err := os.ReadDir(legacyPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	// The files are copied to a temporary directory first, so a failed copy is not used as the database
+	tempPath := dbPath + ".migrating"
+	os.RemoveAll(tempPath)
+	if err := os.MkdirAll(tempPath, 0700); err != nil {
+		return false, err
+	}
+	for _, legacyFile := range legacyFiles {
+		if !legacyFile.Type().IsRegular() {
+			continue
+		}
+		if err := copyFile(filepath.Join(legacyPath, legacyFile.Name()), filepath.Join(tempPath, legacyFile.Name())); err != nil {
+			os.RemoveAll(tempPath)
+			return false, err
+		}
+	}
+	if err := os.Rename(tempPath, dbPath); err != nil {
+		os.RemoveAll(tempPath)
+		return false, err
+	}
+	return true, nil
+}
+
+// copyFile copies the file content to a new file
+func copyFile(sourcePath string, targetPath string) error {
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := os.OpenFile(targetPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(target, source); err != nil {
+		target.Close()
+		return err
+	}
+	return target.Close()
+}
+
+// getProjectFileName gets the file name of the project, scoped package names (@scope/name)
+// are kept in one file
+func getProjectFileName(packageName string) string {
+	return strings.ReplaceAll(packageName, "/", "+")
+}
+
+// getProjectLogPath gets the default stdout log file path of the project
+func getProjectLogPath(packageName string) string {
+	return filepath.Join(paths.LogsDir, getProjectFileName(packageName)+".log")
+}
+
+// getProjectErrorLogPath gets the default stderr log file path of the project
+func getProjectErrorLogPath(packageName string) string {
+	return filepath.Join(paths.LogsDir, getProjectFileName(packageName)+"-error.log")
+}
@@ -0,0 +1,113 @@
+package manager
+
+import (
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultStopSignal  = syscall.SIGTERM
+	defaultKillTimeout = 5000
+	// killWaitTimeout how long to wait for the process group to disappear after SIGKILL
+	killWaitTimeout = 5 * time.Second
+	// processGroupPollInterval how often the process group existence is checked while stopping
+	processGroupPollInterval = 50 * time.Millisecond
+	// processOutputWaitDelay how long to wait for the process output to be closed after the process exited
+	processOutputWaitDelay = 3 * time.Second
+)
+
+// projectProcess the in-memory handle of a project process that is monitored by this manager
+type projectProcess struct {
+	pid      int
+	stopping bool
+	// done is closed after the process is finished and its state is saved
+	done chan struct{}
+}
+
+var (
+	processesLock sync.Mutex
+	processes     = make(map[string]*projectProcess)
+)
+
+// registerProcess registers a started project process
+func registerProcess(packageName string, pid int) *projectProcess {
+	proc := &projectProcess{
+		pid:  pid,
+		done: make(chan struct{}),
+	}
+	processesLock.Lock()
+	processes[packageName] = proc
+	processesLock.Unlock()
+	return proc
+}
+
+// unregisterProcess removes the project process after it is finished
+//
+// Returns true if the process was stopped on purpose by StopProject
+func unregisterProcess(packageName string, proc *projectProcess) bool {
+	processesLock.Lock()
+	defer processesLock.Unlock()
+	if processes[packageName] == proc {
+		delete(processes, packageName)
+	}
+	return proc.stopping
+}
+
+// markProcessStopping marks the project process as intentionally stopped
+//
+// Returns nil if the process is not monitored by this manager (e.g. it was started
+// by a previous server instance)
+func markProcessStopping(packageName string) *projectProcess {
+	processesLock.Lock()
+	defer processesLock.Unlock()
+	proc, ok := processes[packageName]
+	if !ok {
+		return nil
+	}
+	proc.stopping = true
+	return proc
+}
+
+// isProcessGroupRunning checks if any process of the process group is still alive
+func isProcessGroupRunning(pgid int) bool {
+	return syscall.Kill(-pgid, syscall.Signal(0)) != syscall.ESRCH
+}
+
+// waitProcessGroupExit waits until the process group is gone or the timeout is reached
+//
+// Returns true if the process group is gone
+func waitProcessGroupExit(pgid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for isProcessGroupRunning(pgid) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(processGroupPollInterval)
+	}
+	return true
+}
+
+// stopProcessGroup stops the process group gracefully
+//
+// The stop signal is sent to the process group first, if the group is still alive after
+// the kill timeout, SIGKILL is sent.
+// Returns the signal that finally ended the process group
+func stopProcessGroup(pgid int, stopSignal syscall.Signal, killTimeout time.Duration) (syscall.Signal, error) {
+	// Negative PID value is used to signal the process group (process and its childs)
+	if err := syscall.Kill(-pgid, stopSignal); err != nil {
+		if err == syscall.ESRCH {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if stopSignal != syscall.SIGKILL && !waitProcessGroupExit(pgid, killTimeout) {
+		syscall.Kill(-pgid, syscall.SIGKILL)
+		stopSignal = syscall.SIGKILL
+	}
+	if !waitProcessGroupExit(pgid, killWaitTimeout) {
+		return 0, fmt.Errorf("process group %d is still alive after %s", pgid, SignalName(stopSignal))
+	}
+	return stopSignal, nil
+}
@@ -1,13 +1,64 @@
 package manager
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
+
+// ProjectStatus the project process lifecycle status
+type ProjectStatus string
+
+// Project process lifecycle statuses
+const (
+	StatusStarting   ProjectStatus = "starting"
+	StatusOnline     ProjectStatus = "online"
+	StatusStopping   ProjectStatus = "stopping"
+	StatusStopped    ProjectStatus = "stopped"
+	StatusCrashed    ProjectStatus = "crashed"
+	StatusRestarting ProjectStatus = "restarting"
+	StatusErrored    ProjectStatus = "errored"
+)
+
+// statusTransitions the valid transitions from every project status
+var statusTransitions = map[ProjectStatus][]ProjectStatus{
+	StatusStopped:    {StatusStarting},
+	StatusStarting:   {StatusOnline, StatusErrored},
+	StatusOnline:     {StatusStopping, StatusStopped, StatusCrashed},
+	StatusStopping:   {StatusStopped},
+	StatusCrashed:    {StatusRestarting, StatusErrored},
+	StatusRestarting: {StatusStarting, StatusStopped, StatusErrored},
+	StatusErrored:    {StatusStarting},
+}
 
 // ProjectState the project running state
 type ProjectState struct {
-	PID       int       `json:"pid"`
-	StartTime time.Time `json:"start_time"`
-	EndTime   time.Time `json:"end_time"`
-	LogPath   string    `json:"log_path"`
+	PID       int           `json:"pid"`
+	Status    ProjectStatus `json:"status"`
+	StartTime time.Time     `json:"start_time"`
+	EndTime   time.Time     `json:"end_time"`
+	LogPath   string        `json:"log_path"`
+	// ErrorLogPath the stderr log file path of the running project
+	ErrorLogPath string `json:"error_log_path"`
+	// ClusterProcesses the number of cluster processes the project is started with (0 for normal mode)
+	ClusterProcesses int `json:"cluster_processes"`
+	// RestartCount the number of automatic restarts since the project is started
+	RestartCount int `json:"restart_count"`
+	// LastExitCode the exit code of the last project process (-1 if it is terminated by a signal)
+	LastExitCode int `json:"last_exit_code"`
+	// LastExitSignal the signal that terminated the last project process
+	LastExitSignal string `json:"last_exit_signal,omitempty"`
+	// Workers the cluster mode workers state, reported by the cluster master process
+	Workers []WorkerState `json:"workers,omitempty"`
+}
+
+// WorkerState the cluster mode worker process state
+type WorkerState struct {
+	ID           int       `json:"id"`
+	PID          int       `json:"pid"`
+	StartTime    time.Time `json:"start_time"`
+	Restarts     int       `json:"restarts"`
+	LastExitCode int       `json:"last_exit_code"`
+	LastSignal   string    `json:"last_signal"`
 }
 
 // IsRunning returns true if PID is not zero
@@ -17,3 +68,30 @@ func (projectstate *ProjectState) IsRunning() bool {
 	}
 	return false
 }
+
+// GetStatus gets the project status
+//
+// States that are saved without a status are online if the process is running, otherwise stopped
+func (projectstate *ProjectState) GetStatus() ProjectStatus {
+	if projectstate.Status == "" {
+		if projectstate.IsRunning() {
+			return StatusOnline
+		}
+		return StatusStopped
+	}
+	return projectstate.Status
+}
+
+// SetStatus changes the project status
+//
+// Returns an error if the lifecycle transition from the current status is not valid
+func (projectstate *ProjectState) SetStatus(status ProjectStatus) error {
+	currentStatus := projectstate.GetStatus()
+	for _, validStatus := range statusTransitions[currentStatus] {
+		if validStatus == status {
+			projectstate.Status = status
+			return nil
+		}
+	}
+	return fmt.Errorf("project can't be %s while it is %s", status, currentStatus)
+}
@@ -0,0 +1,124 @@
+package manager
+
+import (
+	"sync"
+	"time"
+
+	"github.com/eladyarkoni/bpm/node"
+)
+
+const (
+	defaultMinUptime      = 1000
+	defaultMaxRestarts    = 15
+	defaultRestartWindow  = 60000
+	defaultInitialBackoff = 100
+	defaultMaxBackoff     = 15000
+)
+
+// restartPolicy the project restart policy with the defaults applied
+type restartPolicy struct {
+	minUptime      time.Duration
+	maxRestarts    int
+	restartWindow  time.Duration
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+// restartTracker tracks the automatic restarts of a crashed project
+type restartTracker struct {
+	// restarts the times of the restarts inside the restart window
+	restarts []time.Time
+	// unstableRuns the number of consecutive runs that are crashed before the min uptime
+	unstableRuns int
+	// timer the pending restart
+	timer *time.Timer
+}
+
+var (
+	restartTrackersLock sync.Mutex
+	restartTrackers     = make(map[string]*restartTracker)
+)
+
+// getRestartPolicy gets the project restart policy
+func getRestartPolicy(projectPolicy node.RestartPolicy) restartPolicy {
+	millisecondsOrDefault := func(value int, defaultValue int) time.Duration {
+		if value <= 0 {
+			value = defaultValue
+		}
+		return time.Duration(value) * time.Millisecond
+	}
+	policy := restartPolicy{
+		minUptime:      millisecondsOrDefault(projectPolicy.MinUptime, defaultMinUptime),
+		maxRestarts:    projectPolicy.MaxRestarts,
+		restartWindow:  millisecondsOrDefault(projectPolicy.RestartWindow, defaultRestartWindow),
+		initialBackoff: millisecondsOrDefault(projectPolicy.InitialBackoff, defaultInitialBackoff),
+		maxBackoff:     millisecondsOrDefault(projectPolicy.MaxBackoff, defaultMaxBackoff),
+	}
+	if policy.maxRestarts <= 0 {
+		policy.maxRestarts = defaultMaxRestarts
+	}
+	return policy
+}
+
+// nextRestart decides whether a crashed process should be restarted and how long to wait before
+//
+// Returns false if the restarts inside the restart window reached the max restarts
+func (tracker *restartTracker) nextRestart(policy restartPolicy, uptime time.Duration, now time.Time) (time.Duration, bool) {
+	if uptime >= policy.minUptime {
+		tracker.unstableRuns = 0
+	} else {
+		tracker.unstableRuns++
+	}
+	windowRestarts := tracker.restarts[:0]
+	for _, restartTime := range tracker.restarts {
+		if now.Sub(restartTime) < policy.restartWindow {
+			windowRestarts = append(windowRestarts, restartTime)
+		}
+	}
+	tracker.restarts = windowRestarts
+	if len(tracker.restarts) >= policy.maxRestarts {
+		return 0, false
+	}
+	tracker.restarts = append(tracker.restarts, now)
+	delay := policy.initialBackoff
+	for i := 1; i < tracker.unstableRuns && delay < policy.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > policy.maxBackoff {
+		delay = policy.maxBackoff
+	}
+	return delay, true
+}
+
+// scheduleRestart schedules the restart of a crashed project according to its restart policy
+//
+// Returns the time to wait before the restart, or false if the project should not be restarted
+func scheduleRestart(packageName string, projectPolicy node.RestartPolicy, uptime time.Duration, restart func()) (time.Duration, bool) {
+	restartTrackersLock.Lock()
+	defer restartTrackersLock.Unlock()
+	tracker, ok := restartTrackers[packageName]
+	if !ok {
+		tracker = &restartTracker{}
+		restartTrackers[packageName] = tracker
+	}
+	delay, shouldRestart := tracker.nextRestart(getRestartPolicy(projectPolicy), uptime, time.Now())
+	if !shouldRestart {
+		return 0, false
+	}
+	tracker.timer = time.AfterFunc(delay, restart)
+	return delay, true
+}
+
+// resetRestartTracker clears the project restarts and cancels its pending restart
+//
+// Returns true if a pending restart is canceled
+func resetRestartTracker(packageName string) bool {
+	restartTrackersLock.Lock()
+	defer restartTrackersLock.Unlock()
+	tracker, ok := restartTrackers[packageName]
+	if !ok {
+		return false
+	}
+	delete(restartTrackers, packageName)
+	return tracker.timer != nil && tracker.timer.Stop()
+}
@@ -0,0 +1,28 @@
+package manager
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// getProjectRuntimeDir gets the runtime directory of the project
+//
+// The runtime directory contains the generated cluster mode script and the control socket
+// of the cluster master process
+func getProjectRuntimeDir(packageName string) string {
+	return filepath.Join(paths.RunDir, getProjectFileName(packageName))
+}
+
+// createProjectRuntimeDir creates the runtime directory of the project
+func createProjectRuntimeDir(packageName string) (string, error) {
+	runtimeDir := getProjectRuntimeDir(packageName)
+	if err := os.MkdirAll(runtimeDir, 0700); err != nil {
+		return "", err
+	}
+	return runtimeDir, nil
+}
+
+// removeProjectRuntimeDir removes the runtime directory of the project and its files
+func removeProjectRuntimeDir(packageName string) error {
+	return os.RemoveAll(getProjectRuntimeDir(packageName))
+}
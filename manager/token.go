@@ -0,0 +1,108 @@
+package manager
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	tokenPrefixKey = "token-"
+	// tokenSecretPrefix the prefix of the token secrets, it makes the tokens easy to find in files
+	tokenSecretPrefix = "bpm_"
+	// tokenSecretBytes the number of the random bytes of a token secret
+	tokenSecretBytes = 32
+)
+
+// tokenNamePattern the valid token names
+var tokenNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+// APIToken an api token of the server
+//
+// Only the sha256 hash of the token secret is saved, the secret is returned once when
+// the token is created
+type APIToken struct {
+	Name      string    `json:"name"`
+	Hash      string    `json:"hash,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// hashTokenSecret gets the hex sha256 hash of the token secret
+func hashTokenSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// CreateToken creates a new api token
+//
+// Returns the token secret, the secret can't be read again
+func CreateToken(name string) (string, error) {
+	if !tokenNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid token name %s", name)
+	}
+	if _, err := db.Get([]byte(tokenPrefixKey+name), nil); err == nil {
+		return "", fmt.Errorf("token %s already exists", name)
+	}
+	secretBytes := make([]byte, tokenSecretBytes)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", err
+	}
+	secret := tokenSecretPrefix + hex.EncodeToString(secretBytes)
+	token := APIToken{Name: name, Hash: hashTokenSecret(secret), CreatedAt: time.Now()}
+	tokenBytes, err := json.Marshal(&token)
+	if err != nil {
+		return "", err
+	}
+	if err := db.Put([]byte(tokenPrefixKey+name), tokenBytes, nil); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// RevokeToken deletes the api token, requests with the token are rejected immediately
+func RevokeToken(name string) error {
+	if _, err := db.Get([]byte(tokenPrefixKey+name), nil); err == leveldb.ErrNotFound {
+		return fmt.Errorf("token is not found")
+	}
+	return db.Delete([]byte(tokenPrefixKey+name), nil)
+}
+
+// GetTokens gets the api tokens without their hashes
+func GetTokens() ([]APIToken, error) {
+	tokens := make([]APIToken, 0)
+	tokenIter := db.NewIterator(util.BytesPrefix([]byte(tokenPrefixKey)), nil)
+	defer tokenIter.Release()
+	for tokenIter.Next() {
+		var token APIToken
+		json.Unmarshal(tokenIter.Value(), &token)
+		token.Hash = ""
+		tokens = append(tokens, token)
+	}
+	return tokens, tokenIter.Error()
+}
+
+// VerifyToken gets the api token of the secret, returns false if the secret is not a valid token
+func VerifyToken(secret string) (*APIToken, bool) {
+	if secret == "" {
+		return nil, false
+	}
+	secretHash := []byte(hashTokenSecret(secret))
+	tokenIter := db.NewIterator(util.BytesPrefix([]byte(tokenPrefixKey)), nil)
+	defer tokenIter.Release()
+	for tokenIter.Next() {
+		var token APIToken
+		json.Unmarshal(tokenIter.Value(), &token)
+		if subtle.ConstantTimeCompare(secretHash, []byte(token.Hash)) == 1 {
+			return &token, true
+		}
+	}
+	return nil, false
+}
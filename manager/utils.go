@@ -1,7 +1,10 @@
 package manager
 
 import (
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"syscall"
 )
 
@@ -16,3 +19,44 @@ func IsProcessRunning(pid int) bool {
 	}
 	return true
 }
+
+// signalNames the signals that can be configured as a project stop signal
+var signalNames = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGKILL": syscall.SIGKILL,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+	"SIGTERM": syscall.SIGTERM,
+}
+
+// ParseSignal parses a signal name (SIGTERM, TERM) or number (15)
+func ParseSignal(name string) (syscall.Signal, error) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if num, err := strconv.Atoi(name); err == nil {
+		for _, sig := range signalNames {
+			if int(sig) == num {
+				return sig, nil
+			}
+		}
+		return 0, fmt.Errorf("unsupported signal %d", num)
+	}
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	if sig, ok := signalNames[name]; ok {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("unsupported signal %s", name)
+}
+
+// SignalName gets the signal name (SIGTERM) of the signal
+func SignalName(sig syscall.Signal) string {
+	for name, namedSig := range signalNames {
+		if namedSig == sig {
+			return name
+		}
+	}
+	return fmt.Sprintf("signal %d", int(sig))
+}
@@ -0,0 +1,89 @@
+package node
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DefaultEnvFile the environment file that is loaded from the project working directory
+const DefaultEnvFile = ".env"
+
+// ReadEnvFile reads the environment variables of a dotenv file
+//
+// Every line is a KEY=VALUE pair, optionally prefixed with export.
+// Empty lines and lines that start with # are ignored, values can be single quoted (literal)
+// or double quoted (\n, \t, \" and \\ are escaped).
+func ReadEnvFile(filePath string) (map[string]string, error) {
+	envFile, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer envFile.Close()
+	env := make(map[string]string)
+	scanner := bufio.NewScanner(envFile)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+		separatorIndex := strings.Index(line, "=")
+		if separatorIndex <= 0 {
+			return nil, fmt.Errorf("%s:%d: invalid line, KEY=VALUE is expected", filePath, lineNumber)
+		}
+		key := strings.TrimSpace(line[:separatorIndex])
+		value, valueErr := parseEnvValue(strings.TrimSpace(line[separatorIndex+1:]))
+		if valueErr != nil {
+			return nil, fmt.Errorf("%s:%d: %s", filePath, lineNumber, valueErr)
+		}
+		env[key] = value
+	}
+	return env, scanner.Err()
+}
+
+// parseEnvValue parses the value of a dotenv line
+func parseEnvValue(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	switch value[0] {
+	case '\'':
+		endIndex := strings.Index(value[1:], "'")
+		if endIndex == -1 {
+			return "", fmt.Errorf("unterminated quoted value")
+		}
+		return value[1 : endIndex+1], nil
+	case '"':
+		var parsedValue strings.Builder
+		for i := 1; i < len(value); i++ {
+			switch value[i] {
+			case '"':
+				return parsedValue.String(), nil
+			case '\\':
+				if i+1 < len(value) {
+					i++
+					switch value[i] {
+					case 'n':
+						parsedValue.WriteByte('\n')
+					case 't':
+						parsedValue.WriteByte('\t')
+					default:
+						parsedValue.WriteByte(value[i])
+					}
+					continue
+				}
+			}
+			parsedValue.WriteByte(value[i])
+		}
+		return "", fmt.Errorf("unterminated quoted value")
+	}
+	// Unquoted values can have inline comments
+	if commentIndex := strings.Index(value, " #"); commentIndex != -1 {
+		value = strings.TrimSpace(value[:commentIndex])
+	}
+	return value, nil
+}
@@ -1,5 +1,7 @@
 package node
 
+import "path/filepath"
+
 // NodePackageFile the node package file name
 const NodePackageFile = "package.json"
 
@@ -9,8 +11,10 @@ type Package struct {
 	Description  string            `json:"description"`
 	Version      string            `json:"version"`
 	Main         string            `json:"main"`
+	Type         string            `json:"type"`
 	Dependencies map[string]string `json:"dependencies"`
 	Scripts      map[string]string `json:"scripts"`
+	Engines      map[string]string `json:"engines"`
 }
 
 // GetStartScript gets the package start script
@@ -25,3 +29,22 @@ func (pkg *Package) GetStartScript() string {
 func (pkg *Package) GetMainScript() string {
 	return pkg.Main
 }
+
+// GetNodeEngine gets the node versions range that the package supports (engines.node)
+func (pkg *Package) GetNodeEngine() string {
+	return pkg.Engines["node"]
+}
+
+// IsModuleScript checks if the package script is an ECMAScript module
+//
+// .mjs scripts are modules and .cjs scripts are CommonJS, other scripts are modules
+// if the package type is module
+func (pkg *Package) IsModuleScript(script string) bool {
+	switch filepath.Ext(script) {
+	case ".mjs":
+		return true
+	case ".cjs":
+		return false
+	}
+	return pkg.Type == "module"
+}
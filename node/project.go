@@ -1,7 +1,121 @@
 package node
 
-// Project node project structure
+const (
+	// StartModeMain the interpreter runs the project script or the package main script
+	StartModeMain = "main"
+	// StartModeScript the package start script (scripts.start) is executed
+	StartModeScript = "start"
+)
+
+// Project process project structure
+//
+// A project runs a script by an interpreter, node projects have a package.json file
+// in their working directory and node is their default interpreter.
 type Project struct {
+	// Name the project name (default: the package name)
+	Name       string  `json:"name"`
 	WorkingDir string  `json:"working_dir"`
 	Package    Package `json:"package"`
+	// StartMode how the project is started, main or start (default: main if the package has a main script)
+	StartMode string `json:"start_mode,omitempty"`
+	// Interpreter the command that runs the script (default: node for node projects,
+	// otherwise the script is executed directly)
+	Interpreter string `json:"interpreter,omitempty"`
+	// NodePath the node binary that runs the project (default: resolved by the node version)
+	NodePath string `json:"node_path,omitempty"`
+	// NodeVersion the node version or versions range of the project
+	// (default: .nvmrc, .node-version or engines.node of the package)
+	NodeVersion string `json:"node_version,omitempty"`
+	// NodeArgs the arguments that are passed to node before the script (e.g. --max-old-space-size=4096)
+	NodeArgs []string `json:"node_args,omitempty"`
+	// NodeOptions the NODE_OPTIONS of the project processes, appended to the inherited NODE_OPTIONS
+	NodeOptions string `json:"node_options,omitempty"`
+	// InterpreterArgs the arguments that are passed to the interpreter before the script
+	InterpreterArgs []string `json:"interpreter_args,omitempty"`
+	// Script the script that is run by the interpreter (default: the package main script)
+	Script string `json:"script,omitempty"`
+	// Args the arguments that are passed to the script or appended to the start script
+	Args []string `json:"args,omitempty"`
+	// Env the environment variables of the project processes
+	Env map[string]string `json:"env,omitempty"`
+	// EnvFile the dotenv file path, relative to the working dir (default: .env)
+	EnvFile string `json:"env_file,omitempty"`
+	// EnvProfiles named sets of environment variables (e.g. production, staging)
+	EnvProfiles map[string]map[string]string `json:"env_profiles,omitempty"`
+	// EnvProfile the environment profile that is used when the project is started
+	EnvProfile string `json:"env_profile,omitempty"`
+	// LogPath the stdout log file path (default: <bpm home>/logs/<name>.log)
+	LogPath string `json:"log_path,omitempty"`
+	// ErrorLogPath the stderr log file path (default: <bpm home>/logs/<name>-error.log)
+	ErrorLogPath string `json:"error_log_path,omitempty"`
+	// LogDateFormat the go time layout of the timestamp that prefixes every log line
+	// (e.g. 2006-01-02T15:04:05.000Z07:00), lines are not prefixed if it is empty
+	LogDateFormat string `json:"log_date_format,omitempty"`
+	// LogRotation the policy of rotating the project log file
+	LogRotation LogRotation `json:"log_rotation"`
+	// ClusterProcesses the desired number of cluster mode processes (0 for normal mode)
+	ClusterProcesses int `json:"cluster_processes,omitempty"`
+	// StopSignal the signal that is sent first to stop the project processes (default: SIGTERM)
+	StopSignal string `json:"stop_signal,omitempty"`
+	// KillTimeout milliseconds to wait after the stop signal before sending SIGKILL (default: 5000)
+	KillTimeout int `json:"kill_timeout,omitempty"`
+	// ListenTimeout milliseconds to wait for a new cluster worker to listen while reloading (default: 3000)
+	ListenTimeout int `json:"listen_timeout,omitempty"`
+	// RestartPolicy the policy of restarting the project processes when they crash
+	RestartPolicy RestartPolicy `json:"restart_policy"`
+}
+
+// RestartPolicy project processes restart policy
+//
+// Zero values are replaced by the defaults of the manager
+type RestartPolicy struct {
+	// MinUptime milliseconds the process should run to be considered stable (default: 1000)
+	MinUptime int `json:"min_uptime,omitempty"`
+	// MaxRestarts maximum restarts inside the restart window before the project is errored (default: 15)
+	MaxRestarts int `json:"max_restarts,omitempty"`
+	// RestartWindow milliseconds of the window that restarts are counted in (default: 60000)
+	RestartWindow int `json:"restart_window,omitempty"`
+	// InitialBackoff milliseconds to wait before restarting, doubled for every unstable run (default: 100)
+	InitialBackoff int `json:"initial_backoff,omitempty"`
+	// MaxBackoff the maximum milliseconds to wait before restarting (default: 15000)
+	MaxBackoff int `json:"max_backoff,omitempty"`
+}
+
+// LogRotation project log rotation policy
+//
+// The log file is rotated when it reaches the max size or when the interval is over,
+// zero values are replaced by the defaults of the manager
+type LogRotation struct {
+	// MaxSize bytes of the log file that it is rotated at, -1 disables the size rotation (default: 10485760)
+	MaxSize int64 `json:"max_size,omitempty"`
+	// Interval rotates the log file every hour, day or week: hourly, daily or weekly (default: no time rotation)
+	Interval string `json:"interval,omitempty"`
+	// MaxFiles the number of the rotated files that are kept (default: 5)
+	MaxFiles int `json:"max_files,omitempty"`
+	// Compress compresses the rotated files with gzip
+	Compress bool `json:"compress,omitempty"`
+}
+
+// GetStartMode gets the project start mode
+func (project *Project) GetStartMode() string {
+	if project.StartMode != "" {
+		return project.StartMode
+	}
+	if project.GetScript() == "" && project.Package.GetStartScript() != "" {
+		return StartModeScript
+	}
+	return StartModeMain
+}
+
+// GetScript gets the script that is run in main mode
+func (project *Project) GetScript() string {
+	if project.Script != "" {
+		return project.Script
+	}
+	return project.Package.GetMainScript()
+}
+
+// IsNodeProject checks if the project is a node package
+func (project *Project) IsNodeProject() bool {
+	return project.Package.Name != ""
 }
@@ -0,0 +1,113 @@
+package node
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// shellCharacters the characters that make a script a shell script (pipes, redirects, variables...)
+const shellCharacters = "|&;<>()$`*?[]{}~!#\n"
+
+// nodeValueOptions node options that take their value as the next argument
+var nodeValueOptions = map[string]bool{
+	"-r":                    true,
+	"--require":             true,
+	"--import":              true,
+	"--loader":              true,
+	"--experimental-loader": true,
+	"-C":                    true,
+	"--conditions":          true,
+	"--input-type":          true,
+	"--inspect-port":        true,
+	"--title":               true,
+	"--env-file":            true,
+}
+
+// ParseScript parses an npm script into the command arguments
+//
+// Only simple commands are parsed, the function returns false if the script needs a shell
+// to run (pipes, redirects, variables, environment assignments...).
+// Single and double quotes are supported.
+func ParseScript(script string) ([]string, bool) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+	for _, char := range script {
+		switch {
+		case quote != 0:
+			if char == quote {
+				quote = 0
+			} else if quote == '"' && (char == '\\' || char == '$' || char == '`') {
+				return nil, false
+			} else {
+				current.WriteRune(char)
+			}
+		case char == '\'' || char == '"':
+			quote = char
+			inArg = true
+		case char == '\\' || strings.ContainsRune(shellCharacters, char):
+			return nil, false
+		case char == ' ' || char == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(char)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, false
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	if len(args) == 0 || strings.Contains(args[0], "=") {
+		return nil, false
+	}
+	return args, true
+}
+
+// NodeCommand a command that runs a node script
+type NodeCommand struct {
+	// NodeArgs the node options before the script (e.g. --require dotenv/config)
+	NodeArgs []string
+	// Script the node script path
+	Script string
+	// ScriptArgs the arguments after the script
+	ScriptArgs []string
+}
+
+// ParseNodeCommand parses command arguments that run a node script
+//
+// Returns false if the command is not running node or has no script file
+// (e.g. node --eval, other programs).
+func ParseNodeCommand(args []string) (*NodeCommand, bool) {
+	if len(args) < 2 || filepath.Base(args[0]) != "node" {
+		return nil, false
+	}
+	var nodeCommand NodeCommand
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-e" || arg == "--eval" || arg == "-p" || arg == "--print" || arg == "-" || arg == "--":
+			return nil, false
+		case nodeValueOptions[arg]:
+			if i+1 == len(args) {
+				return nil, false
+			}
+			nodeCommand.NodeArgs = append(nodeCommand.NodeArgs, arg, args[i+1])
+			i++
+		case strings.HasPrefix(arg, "-"):
+			nodeCommand.NodeArgs = append(nodeCommand.NodeArgs, arg)
+		default:
+			nodeCommand.Script = arg
+			nodeCommand.ScriptArgs = args[i+1:]
+			return &nodeCommand, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,217 @@
+package node
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Version a semantic version, pre-release and build metadata are ignored
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// versionComparator compares versions to a version by an operator (<, <=, >, >=, =)
+type versionComparator struct {
+	operator string
+	version  Version
+}
+
+// rangeOperatorSpaces matches spaces between a range operator and its version (e.g. ">= 16")
+var rangeOperatorSpaces = regexp.MustCompile(`(>=|<=|>|<|=|\^|~)\s+`)
+
+// ParseVersion parses a version string (e.g. v18.17.1, 18.17.1)
+func ParseVersion(version string) (Version, error) {
+	parsedVersion, parts, err := parsePartialVersion(version)
+	if err != nil {
+		return Version{}, err
+	}
+	if parts != 3 {
+		return Version{}, fmt.Errorf("invalid version %s", version)
+	}
+	return parsedVersion, nil
+}
+
+// String gets the version string
+func (version Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", version.Major, version.Minor, version.Patch)
+}
+
+// Compare compares the versions, returns -1, 0 or 1
+func (version Version) Compare(other Version) int {
+	for _, diff := range []int{version.Major - other.Major, version.Minor - other.Minor, version.Patch - other.Patch} {
+		if diff < 0 {
+			return -1
+		} else if diff > 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// MatchVersion checks if the version satisfies the npm version range
+//
+// Supported ranges: exact and partial versions (18, 18.2, 18.x, *), comparators (>=16 <19),
+// caret (^18.2.0), tilde (~18.2), hyphen ranges (16 - 18) and alternatives (16 || 18)
+func MatchVersion(version Version, versionRange string) (bool, error) {
+	for _, alternative := range strings.Split(versionRange, "||") {
+		comparators, err := parseVersionRange(strings.TrimSpace(alternative))
+		if err != nil {
+			return false, err
+		}
+		matched := true
+		for _, comparator := range comparators {
+			if !comparator.match(version) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// match checks if the version satisfies the comparator
+func (comparator versionComparator) match(version Version) bool {
+	result := version.Compare(comparator.version)
+	switch comparator.operator {
+	case "<":
+		return result < 0
+	case "<=":
+		return result <= 0
+	case ">":
+		return result > 0
+	case ">=":
+		return result >= 0
+	}
+	return result == 0
+}
+
+// parseVersionRange parses a range without alternatives into comparators
+func parseVersionRange(versionRange string) ([]versionComparator, error) {
+	fields := strings.Fields(rangeOperatorSpaces.ReplaceAllString(versionRange, "$1"))
+	// Hyphen range: from - to
+	if len(fields) == 3 && fields[1] == "-" {
+		from, _, err := parsePartialVersion(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		to, toParts, err := parsePartialVersion(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		comparators := []versionComparator{{">=", from}}
+		if toParts == 3 {
+			comparators = append(comparators, versionComparator{"<=", to})
+		} else if toParts > 0 {
+			comparators = append(comparators, versionComparator{"<", getNextVersion(to, toParts)})
+		}
+		return comparators, nil
+	}
+	comparators := make([]versionComparator, 0)
+	for _, field := range fields {
+		fieldComparators, err := parseVersionComparator(field)
+		if err != nil {
+			return nil, err
+		}
+		comparators = append(comparators, fieldComparators...)
+	}
+	return comparators, nil
+}
+
+// parseVersionComparator parses an operator and a partial version into comparators
+func parseVersionComparator(field string) ([]versionComparator, error) {
+	operator := ""
+	for _, rangeOperator := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(field, rangeOperator) {
+			operator = rangeOperator
+			break
+		}
+	}
+	version, parts, err := parsePartialVersion(strings.TrimPrefix(field, operator))
+	if err != nil {
+		return nil, err
+	}
+	// A wildcard matches any version, except for < and > which match no version
+	if parts == 0 {
+		if operator == "<" || operator == ">" {
+			return []versionComparator{{"<", Version{}}}, nil
+		}
+		return nil, nil
+	}
+	switch operator {
+	case ">=", "<":
+		return []versionComparator{{operator, version}}, nil
+	case ">":
+		if parts == 3 {
+			return []versionComparator{{">", version}}, nil
+		}
+		return []versionComparator{{">=", getNextVersion(version, parts)}}, nil
+	case "<=":
+		if parts == 3 {
+			return []versionComparator{{"<=", version}}, nil
+		}
+		return []versionComparator{{"<", getNextVersion(version, parts)}}, nil
+	case "~":
+		if parts == 3 {
+			parts = 2
+		}
+		return []versionComparator{{">=", version}, {"<", getNextVersion(version, parts)}}, nil
+	case "^":
+		upperBound := Version{Major: version.Major + 1}
+		if version.Major == 0 && parts > 1 {
+			upperBound = Version{Minor: version.Minor + 1}
+			if version.Minor == 0 && parts > 2 {
+				upperBound = Version{Patch: version.Patch + 1}
+			}
+		}
+		return []versionComparator{{">=", version}, {"<", upperBound}}, nil
+	}
+	// Exact or partial version
+	if parts == 3 {
+		return []versionComparator{{"=", version}}, nil
+	}
+	return []versionComparator{{">=", version}, {"<", getNextVersion(version, parts)}}, nil
+}
+
+// getNextVersion gets the first version after the versions that match the partial version
+// (18 -> 19.0.0, 18.2 -> 18.3.0)
+func getNextVersion(version Version, parts int) Version {
+	if parts == 1 {
+		return Version{Major: version.Major + 1}
+	}
+	return Version{Major: version.Major, Minor: version.Minor + 1}
+}
+
+// parsePartialVersion parses a version that may be partial (18, 18.2, 18.x)
+//
+// Returns the version and the number of the specified parts (0 for wildcards)
+func parsePartialVersion(version string) (Version, int, error) {
+	version = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(version), "="), "v")
+	// Pre-release and build metadata are ignored
+	if index := strings.IndexAny(version, "-+"); index >= 0 {
+		version = version[:index]
+	}
+	var numbers [3]int
+	parts := 0
+	for _, part := range strings.Split(version, ".") {
+		if part == "*" || part == "x" || part == "X" || part == "" {
+			break
+		}
+		if parts == 3 {
+			return Version{}, 0, fmt.Errorf("invalid version %s", version)
+		}
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 {
+			return Version{}, 0, fmt.Errorf("invalid version %s", version)
+		}
+		numbers[parts] = number
+		parts++
+	}
+	return Version{Major: numbers[0], Minor: numbers[1], Patch: numbers[2]}, parts, nil
+}
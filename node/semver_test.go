@@ -0,0 +1,136 @@
+package node
+
+import (
+	"testing"
+)
+
+func TestParsingAVersion(t *testing.T) {
+	for _, test := range []struct {
+		version  string
+		expected Version
+		valid    bool
+	}{
+		{"18.17.1", Version{18, 17, 1}, true},
+		{"v18.17.1", Version{18, 17, 1}, true},
+		{"=20.0.0", Version{20, 0, 0}, true},
+		{"18.0.0-rc.1", Version{18, 0, 0}, true},
+		{"18.0.0+build.5", Version{18, 0, 0}, true},
+		{"18.17", Version{}, false},
+		{"18.x.1", Version{}, false},
+		{"18.17.1.2", Version{}, false},
+		{"lts", Version{}, false},
+	} {
+		version, err := ParseVersion(test.version)
+		if (err == nil) != test.valid {
+			t.Fatalf("%s: expected valid %t, got error: %v", test.version, test.valid, err)
+		}
+		if test.valid && version != test.expected {
+			t.Fatalf("%s: expected %s, got %s", test.version, test.expected, version)
+		}
+	}
+}
+
+func TestComparingVersions(t *testing.T) {
+	for _, test := range []struct {
+		version  Version
+		other    Version
+		expected int
+	}{
+		{Version{18, 0, 0}, Version{18, 0, 0}, 0},
+		{Version{18, 0, 0}, Version{20, 0, 0}, -1},
+		{Version{18, 2, 0}, Version{18, 1, 9}, 1},
+		{Version{18, 2, 3}, Version{18, 2, 4}, -1},
+	} {
+		if result := test.version.Compare(test.other); result != test.expected {
+			t.Fatalf("comparing %s to %s: expected %d, got %d", test.version, test.other, test.expected, result)
+		}
+	}
+}
+
+func TestMatchingAVersionRange(t *testing.T) {
+	for _, test := range []struct {
+		versionRange string
+		matched      []string
+		notMatched   []string
+	}{
+		// Exact, partial and wildcard versions
+		{"18.17.1", []string{"18.17.1"}, []string{"18.17.0", "18.17.2"}},
+		{"=18.17.1", []string{"18.17.1"}, []string{"18.17.2"}},
+		{"v18.17.1", []string{"18.17.1"}, []string{"18.17.2"}},
+		{"18", []string{"18.0.0", "18.99.99"}, []string{"17.9.9", "19.0.0"}},
+		{"18.2", []string{"18.2.0", "18.2.9"}, []string{"18.1.9", "18.3.0"}},
+		{"18.x", []string{"18.0.0", "18.20.1"}, []string{"19.0.0"}},
+		{"18.2.x", []string{"18.2.5"}, []string{"18.3.0"}},
+		{"*", []string{"0.0.1", "22.1.0"}, nil},
+		{"x", []string{"22.1.0"}, nil},
+		{"", []string{"22.1.0"}, nil},
+		// Comparators
+		{">=16", []string{"16.0.0", "22.0.0"}, []string{"15.9.9"}},
+		{">= 16", []string{"16.0.0"}, []string{"15.9.9"}},
+		{">16.2.0", []string{"16.2.1"}, []string{"16.2.0"}},
+		{">16", []string{"17.0.0"}, []string{"16.9.9"}},
+		{">16.2", []string{"16.3.0"}, []string{"16.2.9"}},
+		{"<18", []string{"17.9.9"}, []string{"18.0.0"}},
+		{"<=18", []string{"18.9.9"}, []string{"19.0.0"}},
+		{"<=18.2", []string{"18.2.9"}, []string{"18.3.0"}},
+		{"<=18.2.1", []string{"18.2.1"}, []string{"18.2.2"}},
+		{"<*", nil, []string{"0.0.0", "18.0.0"}},
+		{">*", nil, []string{"18.0.0"}},
+		{">=*", []string{"18.0.0"}, nil},
+		{">=16 <19", []string{"16.0.0", "18.9.9"}, []string{"15.9.9", "19.0.0"}},
+		// Tilde ranges
+		{"~1", []string{"1.0.0", "1.9.9"}, []string{"0.9.9", "2.0.0"}},
+		{"~1.2", []string{"1.2.0", "1.2.9"}, []string{"1.3.0"}},
+		{"~1.2.3", []string{"1.2.3", "1.2.9"}, []string{"1.2.2", "1.3.0"}},
+		{"~ 1.2.3", []string{"1.2.3"}, []string{"1.3.0"}},
+		{"~0.2.3", []string{"0.2.3"}, []string{"0.3.0"}},
+		// Caret ranges
+		{"^18.2.0", []string{"18.2.0", "18.99.0"}, []string{"18.1.9", "19.0.0"}},
+		{"^18", []string{"18.0.0", "18.9.9"}, []string{"19.0.0"}},
+		{"^18.x", []string{"18.9.9"}, []string{"19.0.0"}},
+		{"^0.2.3", []string{"0.2.3", "0.2.9"}, []string{"0.2.2", "0.3.0"}},
+		{"^0.2", []string{"0.2.0", "0.2.9"}, []string{"0.3.0"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4", "0.1.0"}},
+		{"^0.0", []string{"0.0.9"}, []string{"0.1.0"}},
+		{"^0.x", []string{"0.0.0", "0.9.9"}, []string{"1.0.0"}},
+		{"^0", []string{"0.9.9"}, []string{"1.0.0"}},
+		// Hyphen ranges
+		{"16 - 18", []string{"16.0.0", "18.9.9"}, []string{"15.9.9", "19.0.0"}},
+		{"1.2 - 2", []string{"1.2.0", "2.9.9"}, []string{"1.1.9", "3.0.0"}},
+		{"1.2.3 - 2.3.4", []string{"1.2.3", "2.3.4"}, []string{"1.2.2", "2.3.5"}},
+		{"1.2.3 - 2.3", []string{"2.3.9"}, []string{"2.4.0"}},
+		{"1.2.3 - *", []string{"1.2.3", "99.0.0"}, []string{"1.2.2"}},
+		// Alternatives
+		{">=1 <2 || 3", []string{"1.0.0", "1.9.9", "3.0.0", "3.9.9"}, []string{"0.9.9", "2.0.0", "4.0.0"}},
+		{"16 || 18 || 20", []string{"16.1.0", "18.0.0", "20.9.0"}, []string{"17.0.0", "19.0.0", "21.0.0"}},
+		{"^14.17.0 || >=16.13", []string{"14.17.0", "16.13.0", "22.0.0"}, []string{"14.16.9", "15.0.0", "16.12.9"}},
+	} {
+		for _, version := range test.matched {
+			if matched, err := MatchVersion(parseTestVersion(t, version), test.versionRange); err != nil || !matched {
+				t.Fatalf("%s should match %q, error: %v", version, test.versionRange, err)
+			}
+		}
+		for _, version := range test.notMatched {
+			if matched, err := MatchVersion(parseTestVersion(t, version), test.versionRange); err != nil || matched {
+				t.Fatalf("%s should not match %q, error: %v", version, test.versionRange, err)
+			}
+		}
+	}
+}
+
+func TestMatchingAnInvalidVersionRange(t *testing.T) {
+	for _, versionRange := range []string{"abc", ">=a.b", "1.2.3.4", "^1.2.3.4", "16 - b", "a - 16", "16 || lts"} {
+		if _, err := MatchVersion(Version{18, 0, 0}, versionRange); err == nil {
+			t.Fatalf("%q should be an invalid version range", versionRange)
+		}
+	}
+}
+
+// parseTestVersion parses a version of the tests
+func parseTestVersion(t *testing.T, version string) Version {
+	parsedVersion, err := ParseVersion(version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsedVersion
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/eladyarkoni/bpm/manager"
+	"github.com/gorilla/mux"
+)
+
+// bearerPrefix the prefix of the authorization header value of api tokens
+const bearerPrefix = "Bearer "
+
+// isUnixSocketRequest checks if the request is received through the unix socket
+func isUnixSocketRequest(req *http.Request) bool {
+	_, ok := req.Context().Value(peerContextKey).(*unixPeer)
+	return ok
+}
+
+// getRequestToken gets the api token of the authorization header
+func getRequestToken(req *http.Request) string {
+	authorization := req.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, bearerPrefix) {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(authorization, bearerPrefix))
+}
+
+// newTokenMiddleware checks that the tcp requests have a valid api token
+//
+// The unix socket requests are checked by their peer credentials
+func newTokenMiddleware() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			if isUnixSocketRequest(req) {
+				next.ServeHTTP(res, req)
+				return
+			}
+			if _, ok := manager.VerifyToken(getRequestToken(req)); !ok {
+				log.Printf("request of %s has no valid api token\n", req.RemoteAddr)
+				res.Header().Set("WWW-Authenticate", `Bearer realm="bpm"`)
+				SendJSON(res, http.StatusUnauthorized, &ResponseObject{
+					Success: false,
+					Message: "unauthorized, a valid api token is required",
+					Data:    []byte("null"),
+				})
+				return
+			}
+			next.ServeHTTP(res, req)
+		})
+	}
+}
+
+// sendUnixSocketOnly sends an error to requests that are not received through the unix socket
+//
+// Returns false if the request is rejected
+func sendUnixSocketOnly(res http.ResponseWriter, req *http.Request) bool {
+	if isUnixSocketRequest(req) {
+		return true
+	}
+	SendJSON(res, http.StatusForbidden, &ResponseObject{
+		Success: false,
+		Message: "tokens can be managed only through the unix socket",
+		Data:    []byte("null"),
+	})
+	return false
+}
+
+// GetTokens gets the api tokens
+func GetTokens(res http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	if !sendUnixSocketOnly(res, req) {
+		return
+	}
+	tokens, err := manager.GetTokens()
+	if err != nil {
+		SendError(res, fmt.Sprintf("%s", err))
+		return
+	}
+	tokensData, _ := json.Marshal(tokens)
+	SendSuccess(res, "Tokens are available", tokensData)
+}
+
+// CreateToken creates an api token
+// Body: json object with the token name
+// Response data: the token secret, it can't be read again
+func CreateToken(res http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	if !sendUnixSocketOnly(res, req) {
+		return
+	}
+	var tokenRequest struct {
+		Name string `json:"name"`
+	}
+	ReadBodyJSON(req, &tokenRequest)
+	if tokenRequest.Name == "" {
+		SendError(res, "token name is empty")
+		return
+	}
+	secret, err := manager.CreateToken(tokenRequest.Name)
+	if err != nil {
+		SendError(res, fmt.Sprintf("%s", err))
+		return
+	}
+	secretData, _ := json.Marshal(secret)
+	SendSuccess(res, fmt.Sprintf("Token %s is created successfully", tokenRequest.Name), secretData)
+}
+
+// RevokeToken revokes an api token
+func RevokeToken(res http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	if !sendUnixSocketOnly(res, req) {
+		return
+	}
+	name := mux.Vars(req)["name"]
+	if err := manager.RevokeToken(name); err != nil {
+		SendError(res, fmt.Sprintf("%s", err))
+		return
+	}
+	SendSuccess(res, fmt.Sprintf("Token %s is revoked successfully", name), nil)
+}
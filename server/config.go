@@ -0,0 +1,185 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/eladyarkoni/bpm/manager"
+)
+
+// Server configuration defaults
+const (
+	DefaultAddress      = "127.0.0.1"
+	DefaultPort         = 9663
+	DefaultReadTimeout  = 15000
+	DefaultWriteTimeout = 15000
+)
+
+// configFileEnv the environment variable of the server configuration file path
+const configFileEnv = "BPM_CONFIG"
+
+// Config the server configuration
+//
+// The configuration is read from the config file of the bpm home directory, zero values
+// are replaced by the defaults
+//
+// The server listens on a unix socket, it listens on tcp too if tcp is enabled.
+// Tcp requests must be authenticated by an api token, tcp is served with tls if the
+// certificate files are configured
+type Config struct {
+	// Socket the unix socket path that the server listens on (default: <bpm home>/bpm.sock)
+	Socket string `json:"socket,omitempty"`
+	// SocketGroup the group that can connect to the unix socket, in addition to the server owner
+	SocketGroup string `json:"socket_group,omitempty"`
+	// TCP listens on the tcp address and port too
+	TCP bool `json:"tcp,omitempty"`
+	// Address the tcp address that the server listens on (default: 127.0.0.1)
+	Address string `json:"address,omitempty"`
+	// Port the tcp port that the server listens on (default: 9663)
+	Port int `json:"port,omitempty"`
+	// TLSCertFile the pem certificate file of the tcp listener
+	TLSCertFile string `json:"tls_cert_file,omitempty"`
+	// TLSKeyFile the pem private key file of the tcp listener
+	TLSKeyFile string `json:"tls_key_file,omitempty"`
+	// DBPath the database directory (default: <bpm home>/db)
+	DBPath string `json:"db_path,omitempty"`
+	// LogsDir the directory of the project and server logs (default: <bpm home>/logs)
+	LogsDir string `json:"logs_dir,omitempty"`
+	// ReadTimeout milliseconds to read a request (default: 15000)
+	ReadTimeout int `json:"read_timeout,omitempty"`
+	// WriteTimeout milliseconds to write a response (default: 15000)
+	WriteTimeout int `json:"write_timeout,omitempty"`
+}
+
+// GetConfigFilePath gets the server configuration file path, BPM_CONFIG or the config
+// file of the bpm home directory
+func GetConfigFilePath() string {
+	if configFilePath := os.Getenv(configFileEnv); configFilePath != "" {
+		return configFilePath
+	}
+	return manager.GetPaths().ConfigFile
+}
+
+// LoadConfig loads the server configuration file (yaml or json)
+//
+// If the file doesn't exist, the default configuration is returned
+func LoadConfig(filePath string) (*Config, error) {
+	var config Config
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		return config.withDefaults(), nil
+	}
+	if err := manager.ReadConfigFile(filePath, &config); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %s", filePath, err)
+	}
+	return config.withDefaults(), nil
+}
+
+// withDefaults replaces the zero values of the configuration by the defaults
+func (config *Config) withDefaults() *Config {
+	if config.Address == "" {
+		config.Address = DefaultAddress
+	}
+	if config.Port == 0 {
+		config.Port = DefaultPort
+	}
+	if config.ReadTimeout == 0 {
+		config.ReadTimeout = DefaultReadTimeout
+	}
+	if config.WriteTimeout == 0 {
+		config.WriteTimeout = DefaultWriteTimeout
+	}
+	return config
+}
+
+// ConfigOverrides the server flags that override the configuration
+type ConfigOverrides struct {
+	// Config the values that override the configuration, zero values don't override it
+	Config
+	// TCP overrides tcp if it is set, so tcp can be disabled too (--tcp=false)
+	TCP *bool
+}
+
+// Override overrides the configuration by the values of the overrides (the server flags)
+func (config *Config) Override(overrides *ConfigOverrides) {
+	if overrides.Socket != "" {
+		config.Socket = overrides.Socket
+	}
+	if overrides.SocketGroup != "" {
+		config.SocketGroup = overrides.SocketGroup
+	}
+	if overrides.TCP != nil {
+		config.TCP = *overrides.TCP
+	}
+	if overrides.Address != "" {
+		config.Address = overrides.Address
+	}
+	if overrides.Port != 0 {
+		config.Port = overrides.Port
+	}
+	if overrides.TLSCertFile != "" {
+		config.TLSCertFile = overrides.TLSCertFile
+	}
+	if overrides.TLSKeyFile != "" {
+		config.TLSKeyFile = overrides.TLSKeyFile
+	}
+	if overrides.DBPath != "" {
+		config.DBPath = overrides.DBPath
+	}
+	if overrides.LogsDir != "" {
+		config.LogsDir = overrides.LogsDir
+	}
+	if overrides.ReadTimeout != 0 {
+		config.ReadTimeout = overrides.ReadTimeout
+	}
+	if overrides.WriteTimeout != 0 {
+		config.WriteTimeout = overrides.WriteTimeout
+	}
+}
+
+// Validate validates the server configuration
+func (config *Config) Validate() error {
+	if config.Port < 0 || config.Port > 65535 {
+		return fmt.Errorf("invalid port %d", config.Port)
+	}
+	if config.ReadTimeout < 0 || config.WriteTimeout < 0 {
+		return fmt.Errorf("timeouts can't be negative")
+	}
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		return fmt.Errorf("tls_cert_file and tls_key_file must be configured together")
+	}
+	return nil
+}
+
+// GetSocketPath gets the unix socket path of the server
+func (config *Config) GetSocketPath() string {
+	if config.Socket != "" {
+		return config.Socket
+	}
+	return config.GetPaths().Socket
+}
+
+// GetListenAddress gets the tcp address that the server listens on (host:port)
+func (config *Config) GetListenAddress() string {
+	return net.JoinHostPort(config.Address, strconv.Itoa(config.Port))
+}
+
+// IsTLS checks if the tcp listener is served with tls
+func (config *Config) IsTLS() bool {
+	return config.TLSCertFile != "" && config.TLSKeyFile != ""
+}
+
+// GetPaths gets the layout of the bpm home directory with the configured paths
+func (config *Config) GetPaths() *manager.Paths {
+	layout := *manager.GetPaths()
+	if config.DBPath != "" {
+		layout.DB = config.DBPath
+	}
+	if config.LogsDir != "" {
+		layout.LogsDir = config.LogsDir
+		layout.ServerLogFile = filepath.Join(config.LogsDir, filepath.Base(layout.ServerLogFile))
+	}
+	return &layout
+}
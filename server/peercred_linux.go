@@ -0,0 +1,29 @@
+//go:build linux
+// +build linux
+
+package server
+
+import (
+	"net"
+	"syscall"
+)
+
+// getPeerCredentials gets the credentials of the unix socket peer (SO_PEERCRED)
+func getPeerCredentials(conn *net.UnixConn) (*peerCredentials, error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var ucred *syscall.Ucred
+	var credErr error
+	controlErr := rawConn.Control(func(fd uintptr) {
+		ucred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if controlErr != nil {
+		return nil, controlErr
+	}
+	if credErr != nil {
+		return nil, credErr
+	}
+	return &peerCredentials{PID: int(ucred.Pid), UID: int(ucred.Uid), GID: int(ucred.Gid)}, nil
+}
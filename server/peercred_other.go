@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package server
+
+import "net"
+
+// getPeerCredentials gets the credentials of the unix socket peer
+//
+// Peer credentials are supported only on linux, the socket file permissions protect the socket
+func getPeerCredentials(conn *net.UnixConn) (*peerCredentials, error) {
+	return nil, errPeerCredentialsUnsupported
+}
@@ -1,9 +1,15 @@
 package server
 
 import (
+	"crypto/tls"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"regexp"
 	"time"
 
 	"encoding/json"
@@ -11,32 +17,109 @@ import (
 	"strconv"
 
 	"github.com/eladyarkoni/bpm/manager"
+	"github.com/eladyarkoni/bpm/node"
 	"github.com/gorilla/mux"
 )
 
+// logStreamKeepAliveInterval how often a comment is sent to idle log streams
+const logStreamKeepAliveInterval = 15 * time.Second
+
 // Start Starts the server listener
-func Start(port string) error {
-	manager.Init()
+func Start(config *Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	manager.SetPaths(config.GetPaths())
+	if err := manager.Init(); err != nil {
+		return err
+	}
+	pidFile := manager.GetPaths().PIDFile
+	if err := ioutil.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())), 0600); err != nil {
+		return err
+	}
+	defer os.Remove(pidFile)
 	serverRouter := mux.NewRouter()
 	serverRouter.HandleFunc("/status", GetServerStatus).Methods("GET")
 	serverRouter.HandleFunc("/manager/status", GetManagerStatus).Methods("GET")
 	serverRouter.HandleFunc("/manager/project", AddProject).Methods("POST")
+	serverRouter.HandleFunc("/manager/apply", ApplyEcosystem).Methods("POST")
 	serverRouter.HandleFunc("/manager/project/{package}", GetProject).Methods("GET")
+	serverRouter.HandleFunc("/manager/project/{package}", UpdateProject).Methods("PUT")
 	serverRouter.HandleFunc("/manager/project/{package}/log", GetProjectLog).Methods("GET")
+	serverRouter.HandleFunc("/manager/project/{package}/log", FlushProjectLog).Methods("DELETE")
+	serverRouter.HandleFunc("/manager/project/{package}/log/stream", StreamProjectLog).Methods("GET")
 	serverRouter.HandleFunc("/manager/project/{package}", RemoveProject).Methods("DELETE")
 	serverRouter.HandleFunc("/manager/project/{package}/status", GetProjectStatus).Methods("GET")
+	serverRouter.HandleFunc("/manager/project/{package}/history", GetProjectHistory).Methods("GET")
+	serverRouter.HandleFunc("/manager/project/{package}/env", GetProjectEnv).Methods("GET")
+	serverRouter.HandleFunc("/manager/project/{package}/env", SetProjectEnv).Methods("PUT")
+	serverRouter.HandleFunc("/manager/project/{package}/env/{key}", UnsetProjectEnv).Methods("DELETE")
 	serverRouter.HandleFunc("/manager/project/{package}/start", StartProject).Methods("POST")
 	serverRouter.HandleFunc("/manager/project/{package}/stop", StopProject).Methods("POST")
+	serverRouter.HandleFunc("/manager/project/{package}/restart", RestartProject).Methods("POST")
+	serverRouter.HandleFunc("/manager/project/{package}/reload", ReloadProject).Methods("POST")
+	serverRouter.HandleFunc("/manager/project/{package}/scale", ScaleProject).Methods("POST")
+	serverRouter.HandleFunc("/tokens", GetTokens).Methods("GET")
+	serverRouter.HandleFunc("/tokens", CreateToken).Methods("POST")
+	serverRouter.HandleFunc("/tokens/{name}", RevokeToken).Methods("DELETE")
 	http.Handle("/", serverRouter)
-	srv := &http.Server{
-		Handler:      serverRouter,
-		Addr:         fmt.Sprintf("127.0.0.1:%s", port),
-		WriteTimeout: 15 * time.Second,
-		ReadTimeout:  15 * time.Second,
+	socketGroupID, err := lookupGroupID(config.SocketGroup)
+	if err != nil {
+		return err
+	}
+	serverRouter.Use(newPeerCredentialsMiddleware(socketGroupID), newTokenMiddleware())
+	// The listeners have their own servers, a server that serves plain connections doesn't
+	// negotiate http2 on tls connections
+	srv := newHTTPServer(serverRouter, config)
+	tcpSrv := newHTTPServer(serverRouter, config)
+	if config.TCP && config.IsTLS() {
+		certificate, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			return fmt.Errorf("can't load the tls certificate: %s", err)
+		}
+		tcpSrv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{certificate}}
+	}
+	unixListener, err := listenUnixSocket(config.GetSocketPath(), socketGroupID)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(config.GetSocketPath())
+	serveErrors := make(chan error, 2)
+	if config.TCP {
+		tcpListener, err := net.Listen("tcp", config.GetListenAddress())
+		if err != nil {
+			unixListener.Close()
+			return err
+		}
+		if !config.IsTLS() {
+			if ip := net.ParseIP(config.Address); ip == nil || !ip.IsLoopback() {
+				log.Printf("Warning: tcp is served without tls, api tokens are sent in plain text")
+			}
+		}
+		log.Printf("Bulk Server is started on %s (tls: %t)", config.GetListenAddress(), config.IsTLS())
+		go func() {
+			if config.IsTLS() {
+				serveErrors <- tcpSrv.ServeTLS(tcpListener, "", "")
+				return
+			}
+			serveErrors <- tcpSrv.Serve(tcpListener)
+		}()
+	}
+	log.Printf("Bulk Server is started on %s", config.GetSocketPath())
+	go func() {
+		serveErrors <- srv.Serve(unixListener)
+	}()
+	return <-serveErrors
+}
+
+// newHTTPServer creates an http server of the router with the configured timeouts
+func newHTTPServer(handler http.Handler, config *Config) *http.Server {
+	return &http.Server{
+		Handler:      handler,
+		ConnContext:  getConnContext,
+		WriteTimeout: time.Duration(config.WriteTimeout) * time.Millisecond,
+		ReadTimeout:  time.Duration(config.ReadTimeout) * time.Millisecond,
 	}
-	log.Printf("Bulk Server is started on port %s", port)
-	err := srv.ListenAndServe()
-	return err
 }
 
 // GetServerStatus gets server status
@@ -54,21 +137,52 @@ func GetManagerStatus(res http.ResponseWriter, req *http.Request) {
 }
 
 // AddProject adds a new project to manager
+// Body: the project json, node projects need only the working_dir
+// Response data: warnings about the added project
 func AddProject(res http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
-	var requestBody struct {
-		WorkingDir string `json:"working_dir"`
-	}
-	ReadBodyJSON(req, &requestBody)
-	if requestBody.WorkingDir == "" {
+	var project node.Project
+	ReadBodyJSON(req, &project)
+	if project.WorkingDir == "" {
 		SendError(res, "working_dir is empty")
 		return
 	}
-	if err := manager.AddProject(requestBody.WorkingDir); err != nil {
+	warnings, err := manager.RegisterProject(&project)
+	if err != nil {
 		SendError(res, fmt.Sprintf("%s", err))
 		return
 	}
-	SendSuccess(res, "Project is added successfully", nil)
+	warningsData, _ := json.Marshal(warnings)
+	SendSuccess(res, fmt.Sprintf("Project %s is added successfully", project.Name), warningsData)
+}
+
+// ApplyEcosystem reconciles the manager projects with the ecosystem
+// Body: the ecosystem file json
+// Query params: dryRun = true to get the changes without applying them
+func ApplyEcosystem(res http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	var ecosystem manager.EcosystemFile
+	ReadBodyJSON(req, &ecosystem)
+	var changes []manager.ApplyChange
+	var err error
+	if req.URL.Query().Get("dryRun") == "true" {
+		changes, err = manager.PlanEcosystem(&ecosystem)
+	} else {
+		// Stopping and starting the projects may take longer than the write timeout
+		http.NewResponseController(res).SetWriteDeadline(time.Time{})
+		changes, err = manager.ApplyEcosystem(&ecosystem)
+	}
+	changesData, _ := json.Marshal(changes)
+	if err != nil {
+		// The changes that are applied before the error are sent with it
+		SendJSON(res, http.StatusInternalServerError, &ResponseObject{
+			Success: false,
+			Message: fmt.Sprintf("%s", err),
+			Data:    changesData,
+		})
+		return
+	}
+	SendSuccess(res, "Ecosystem is applied successfully", changesData)
 }
 
 // GetProject gets the project data
@@ -85,8 +199,25 @@ func GetProject(res http.ResponseWriter, req *http.Request) {
 	SendSuccess(res, "Project data is available", projectModelData)
 }
 
+// UpdateProject updates the project configuration
+// Body: json object with the project fields to update
+func UpdateProject(res http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	params := mux.Vars(req)
+	packageName := params["package"]
+	projectConfig, _ := ioutil.ReadAll(req.Body)
+	projectModel, err := manager.UpdateProject(packageName, projectConfig)
+	if err != nil {
+		SendError(res, fmt.Sprintf("%s", err))
+		return
+	}
+	projectModelData, _ := json.Marshal(projectModel)
+	SendSuccess(res, "Project is updated successfully", projectModelData)
+}
+
 // GetProjectLog gets the project log lines
-// Query params: lines = <last lines limit>
+// Query params: lines = <last lines limit>, stream = out or err (default: both streams merged),
+// since, until and grep filter the lines (see getLogFilter)
 func GetProjectLog(res http.ResponseWriter, req *http.Request) {
 	linesLimit := 10
 	defer req.Body.Close()
@@ -99,7 +230,12 @@ func GetProjectLog(res http.ResponseWriter, req *http.Request) {
 			linesLimit = queryLinesLimit
 		}
 	}
-	logLines, logErr := manager.GetProjectLogContent(packageName, linesLimit)
+	logFilter, err := getLogFilter(queryParams)
+	if err != nil {
+		SendError(res, fmt.Sprintf("%s", err))
+		return
+	}
+	logLines, logErr := manager.GetProjectLogContent(packageName, queryParams.Get("stream"), linesLimit, logFilter)
 	if logErr != nil {
 		SendError(res, fmt.Sprintf("%s", logErr))
 		return
@@ -108,6 +244,105 @@ func GetProjectLog(res http.ResponseWriter, req *http.Request) {
 	SendSuccess(res, "log is ready", logLinesJSON)
 }
 
+// StreamProjectLog streams the project log lines as server-sent events
+// The last lines are sent first, then the lines are sent as they are written until the client disconnects
+// Query params: lines = <last lines limit, 0 for none>, stream = out or err (default: both streams),
+// since and grep filter the lines like GetProjectLog
+func StreamProjectLog(res http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	params := mux.Vars(req)
+	packageName := params["package"]
+	queryParams := req.URL.Query()
+	linesLimit := 10
+	if queryLinesLimit, err := strconv.Atoi(queryParams.Get("lines")); err == nil {
+		linesLimit = queryLinesLimit
+	}
+	if linesLimit == 0 {
+		linesLimit = -1
+	}
+	logFilter, err := getLogFilter(queryParams)
+	if err != nil {
+		SendError(res, fmt.Sprintf("%s", err))
+		return
+	}
+	logLines, err := manager.FollowProjectLogs(packageName, queryParams.Get("stream"), linesLimit, logFilter, req.Context().Done())
+	if err != nil {
+		SendError(res, fmt.Sprintf("%s", err))
+		return
+	}
+	// The stream is open until the client disconnects, it is not limited by the write timeout
+	responseController := http.NewResponseController(res)
+	responseController.SetWriteDeadline(time.Time{})
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.WriteHeader(http.StatusOK)
+	responseController.Flush()
+	keepAlive := time.NewTicker(logStreamKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case logLine, ok := <-logLines:
+			if !ok {
+				return
+			}
+			logLineData, _ := json.Marshal(logLine)
+			fmt.Fprintf(res, "data: %s\n\n", logLineData)
+		case <-keepAlive.C:
+			fmt.Fprint(res, ": keep-alive\n\n")
+		}
+		if err := responseController.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// getLogFilter gets the log filter of the query params, nil if the lines are not filtered
+// Query params: since, until = <duration before now or time>, grep = <regular expression>,
+// where = <condition on a field of the JSON lines, e.g. level>=warn or reqId=abc>, it can be repeated
+func getLogFilter(queryParams url.Values) (*manager.LogFilter, error) {
+	if queryParams.Get("since") == "" && queryParams.Get("until") == "" && queryParams.Get("grep") == "" && len(queryParams["where"]) == 0 {
+		return nil, nil
+	}
+	logFilter := &manager.LogFilter{}
+	now := time.Now()
+	var err error
+	if since := queryParams.Get("since"); since != "" {
+		if logFilter.Since, err = manager.ParseLogTime(since, now); err != nil {
+			return nil, err
+		}
+	}
+	if until := queryParams.Get("until"); until != "" {
+		if logFilter.Until, err = manager.ParseLogTime(until, now); err != nil {
+			return nil, err
+		}
+	}
+	if grep := queryParams.Get("grep"); grep != "" {
+		if logFilter.Pattern, err = regexp.Compile(grep); err != nil {
+			return nil, fmt.Errorf("invalid grep pattern: %s", err)
+		}
+	}
+	for _, where := range queryParams["where"] {
+		condition, err := manager.ParseLogCondition(where)
+		if err != nil {
+			return nil, err
+		}
+		logFilter.Conditions = append(logFilter.Conditions, condition)
+	}
+	return logFilter, nil
+}
+
+// FlushProjectLog clears the project log file and deletes its rotated files
+func FlushProjectLog(res http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	params := mux.Vars(req)
+	packageName := params["package"]
+	if err := manager.FlushProjectLogs(packageName); err != nil {
+		SendError(res, fmt.Sprintf("%s", err))
+		return
+	}
+	SendSuccess(res, "Project logs are flushed successfully", nil)
+}
+
 // RemoveProject removes the project
 func RemoveProject(res http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
@@ -131,10 +366,80 @@ func GetProjectStatus(res http.ResponseWriter, req *http.Request) {
 		SendError(res, fmt.Sprintf("%s", err))
 		return
 	}
+	if projectStateModel.IsRunning() && projectStateModel.ClusterProcesses > 0 {
+		projectStateModel.Workers, _ = manager.GetProjectWorkers(packageName)
+	}
 	projectStateModelData, _ := json.Marshal(projectStateModel)
 	SendSuccess(res, "Project status is available", projectStateModelData)
 }
 
+// GetProjectHistory gets the project runs history
+// Query params: limit = <last runs limit>
+func GetProjectHistory(res http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	params := mux.Vars(req)
+	packageName := params["package"]
+	limit := 0
+	if queryLimit, err := strconv.Atoi(req.URL.Query().Get("limit")); err == nil {
+		limit = queryLimit
+	}
+	projectRuns, err := manager.GetProjectHistory(packageName, limit)
+	if err != nil {
+		SendError(res, fmt.Sprintf("%s", err))
+		return
+	}
+	projectRunsData, _ := json.Marshal(projectRuns)
+	SendSuccess(res, "Project history is available", projectRunsData)
+}
+
+// GetProjectEnv gets the project environment variables
+// Query params: profile = <environment profile name>
+func GetProjectEnv(res http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	params := mux.Vars(req)
+	packageName := params["package"]
+	projectEnv, err := manager.GetProjectEnv(packageName, req.URL.Query().Get("profile"))
+	if err != nil {
+		SendError(res, fmt.Sprintf("%s", err))
+		return
+	}
+	projectEnvData, _ := json.Marshal(projectEnv)
+	SendSuccess(res, "Project environment is available", projectEnvData)
+}
+
+// SetProjectEnv sets the project environment variables
+// Body: json object of variable names and values
+// Query params: profile = <environment profile name>
+func SetProjectEnv(res http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	params := mux.Vars(req)
+	packageName := params["package"]
+	var env map[string]string
+	ReadBodyJSON(req, &env)
+	if len(env) == 0 {
+		SendError(res, "environment variables are missing")
+		return
+	}
+	if err := manager.SetProjectEnv(packageName, req.URL.Query().Get("profile"), env); err != nil {
+		SendError(res, fmt.Sprintf("%s", err))
+		return
+	}
+	SendSuccess(res, "Project environment is updated successfully", nil)
+}
+
+// UnsetProjectEnv removes a project environment variable
+// Query params: profile = <environment profile name>
+func UnsetProjectEnv(res http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	params := mux.Vars(req)
+	packageName := params["package"]
+	if err := manager.UnsetProjectEnv(packageName, req.URL.Query().Get("profile"), []string{params["key"]}); err != nil {
+		SendError(res, fmt.Sprintf("%s", err))
+		return
+	}
+	SendSuccess(res, "Project environment is updated successfully", nil)
+}
+
 // StartProject starts the project processes
 func StartProject(res http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
@@ -145,6 +450,13 @@ func StartProject(res http.ResponseWriter, req *http.Request) {
 	if queryParams.Get("clusterProcesses") != "" {
 		clusterProcesses, _ = strconv.Atoi(queryParams.Get("clusterProcesses"))
 	}
+	// The selected profile is saved, it is used until another profile is selected or it is cleared
+	if envProfile := queryParams.Get("envProfile"); envProfile != "" || queryParams.Get("clearEnvProfile") == "true" {
+		if err := manager.SetProjectEnvProfile(packageName, envProfile); err != nil {
+			SendError(res, fmt.Sprintf("%v", err))
+			return
+		}
+	}
 	startProjectErr := manager.StartProject(packageName, clusterProcesses, nil)
 	if startProjectErr != nil {
 		log.Printf("StartProject %s error: %s\n", packageName, startProjectErr)
@@ -156,12 +468,73 @@ func StartProject(res http.ResponseWriter, req *http.Request) {
 
 // StopProject stops the project processes
 func StopProject(res http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
 	params := mux.Vars(req)
 	packageName := params["package"]
-	stopProjectErr := manager.StopProject(packageName)
+	// A graceful stop waits for the kill timeout, it may take longer than the write timeout
+	http.NewResponseController(res).SetWriteDeadline(time.Time{})
+	stopSignal, stopProjectErr := manager.StopProject(packageName)
 	if stopProjectErr != nil {
 		SendError(res, fmt.Sprintf("%s", stopProjectErr))
 		return
 	}
-	SendSuccess(res, "Project is stopped successfully", nil)
+	if stopSignal == 0 {
+		SendSuccess(res, "Project is stopped successfully", nil)
+		return
+	}
+	SendSuccess(res, fmt.Sprintf("Project is stopped successfully by %s", manager.SignalName(stopSignal)), nil)
+}
+
+// RestartProject restarts the project processes
+func RestartProject(res http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	params := mux.Vars(req)
+	packageName := params["package"]
+	// Restarting waits for the graceful stop and the start, it may take longer than the write timeout
+	http.NewResponseController(res).SetWriteDeadline(time.Time{})
+	restartProjectErr := manager.RestartProject(packageName, nil)
+	if restartProjectErr != nil {
+		log.Printf("RestartProject %s error: %s\n", packageName, restartProjectErr)
+		SendError(res, fmt.Sprintf("%v", restartProjectErr))
+		return
+	}
+	SendSuccess(res, "Project is restarted successfully", nil)
+}
+
+// ReloadProject replaces the project cluster workers one at a time
+func ReloadProject(res http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	params := mux.Vars(req)
+	packageName := params["package"]
+	// Reloading waits for every worker to be replaced, it may take longer than the write timeout
+	http.NewResponseController(res).SetWriteDeadline(time.Time{})
+	reloadProjectErr := manager.ReloadProject(packageName)
+	if reloadProjectErr != nil {
+		log.Printf("ReloadProject %s error: %s\n", packageName, reloadProjectErr)
+		SendError(res, fmt.Sprintf("%v", reloadProjectErr))
+		return
+	}
+	SendSuccess(res, "Project is reloaded successfully", nil)
+}
+
+// ScaleProject changes the number of the project cluster mode processes
+// Query params: clusterProcesses = <number of cluster processes>
+func ScaleProject(res http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+	params := mux.Vars(req)
+	packageName := params["package"]
+	clusterProcesses, err := strconv.Atoi(req.URL.Query().Get("clusterProcesses"))
+	if err != nil {
+		SendError(res, "clusterProcesses is not a number")
+		return
+	}
+	// Stopping the extra workers may take longer than the write timeout
+	http.NewResponseController(res).SetWriteDeadline(time.Time{})
+	scaleProjectErr := manager.ScaleProject(packageName, clusterProcesses)
+	if scaleProjectErr != nil {
+		log.Printf("ScaleProject %s error: %s\n", packageName, scaleProjectErr)
+		SendError(res, fmt.Sprintf("%v", scaleProjectErr))
+		return
+	}
+	SendSuccess(res, fmt.Sprintf("Project is scaled to %d processes successfully", clusterProcesses), nil)
 }
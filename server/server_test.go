@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRejectingUnixSocketPeers(t *testing.T) {
+	handler := newPeerCredentialsMiddleware(-1)(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusOK)
+	}))
+	otherUID := os.Getuid() + 12345
+	peers := map[string]struct {
+		peer         *unixPeer
+		expectedCode int
+	}{
+		"owner":                 {&unixPeer{credentials: &peerCredentials{UID: os.Getuid(), GID: os.Getgid()}}, http.StatusOK},
+		"root":                  {&unixPeer{credentials: &peerCredentials{UID: 0}}, http.StatusOK},
+		"other user":            {&unixPeer{credentials: &peerCredentials{UID: otherUID, GID: otherUID}}, http.StatusForbidden},
+		"unknown credentials":   {&unixPeer{err: net.ErrClosed}, http.StatusForbidden},
+		"unsupported peer cred": {&unixPeer{err: errPeerCredentialsUnsupported}, http.StatusOK},
+	}
+	for name, testPeer := range peers {
+		req := httptest.NewRequest("GET", "/manager/status", nil)
+		req = req.WithContext(context.WithValue(req.Context(), peerContextKey, testPeer.peer))
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		if res.Code != testPeer.expectedCode {
+			t.Fatalf("request of %s peer should get %d, got: %d", name, testPeer.expectedCode, res.Code)
+		}
+	}
+
+	// Members of the socket group are allowed
+	groupPeer := &unixPeer{credentials: &peerCredentials{UID: otherUID, GID: 4242}}
+	if isPeerAllowed(groupPeer, -1) || !isPeerAllowed(groupPeer, 4242) {
+		t.Fatal("peer should be allowed only by the socket group")
+	}
+}
+
+func TestServingTheUnixSocketOwner(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "bpm.sock")
+	listener, err := listenUnixSocket(socketPath, -1)
+	if err != nil {
+		t.Fatalf("Failed to listen on the unix socket, error: %s", err)
+	}
+	if socketInfo, _ := os.Stat(socketPath); socketInfo.Mode().Perm() != 0600 {
+		t.Fatalf("unix socket should be accessible only by the owner, got: %s", socketInfo.Mode().Perm())
+	}
+	srv := &http.Server{
+		Handler: newPeerCredentialsMiddleware(-1)(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			if !isUnixSocketRequest(req) {
+				res.WriteHeader(http.StatusBadRequest)
+			}
+		})),
+		ConnContext: getConnContext,
+	}
+	go srv.Serve(listener)
+	defer srv.Close()
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+	}}
+	res, err := client.Get("http://bpm/manager/status")
+	if err != nil {
+		t.Fatalf("Failed to send a request to the unix socket, error: %s", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("request of the socket owner should be served, got: %d", res.StatusCode)
+	}
+}
+
+func TestServerConfigPrecedence(t *testing.T) {
+	configFilePath := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(configFilePath, []byte("address: 0.0.0.0\nport: 7000\nread_timeout: 5000\ntls_cert_file: file.pem\ntls_key_file: file.key\n"), 0640)
+	t.Setenv(configFileEnv, configFilePath)
+	if GetConfigFilePath() != configFilePath {
+		t.Fatalf("config file should be %s of %s, got: %s", configFilePath, configFileEnv, GetConfigFilePath())
+	}
+
+	// The config file values replace the defaults
+	config, err := LoadConfig(GetConfigFilePath())
+	if err != nil {
+		t.Fatalf("Failed to load the config file, error: %s", err)
+	}
+	if config.Address != "0.0.0.0" || config.Port != 7000 || config.ReadTimeout != 5000 || config.WriteTimeout != DefaultWriteTimeout || config.TLSCertFile != "file.pem" {
+		t.Fatalf("config file values should replace the defaults, got: %+v", config)
+	}
+
+	// The flags override the config file values
+	enabled, disabled := true, false
+	config.Override(&ConfigOverrides{Config: Config{Port: 8000, TLSCertFile: "cert.pem", TLSKeyFile: "key.pem"}, TCP: &enabled})
+	if config.Port != 8000 || !config.TCP || config.Address != "0.0.0.0" || config.ReadTimeout != 5000 {
+		t.Fatalf("flags should override only their config values, got: %+v", config)
+	}
+	if config.TLSCertFile != "cert.pem" || config.TLSKeyFile != "key.pem" {
+		t.Fatalf("tls flags should override the config tls files, got: %+v", config)
+	}
+	config.Override(&ConfigOverrides{})
+	if !config.TCP || config.TLSCertFile != "cert.pem" {
+		t.Fatalf("flags that are not set should not override the config values, got: %+v", config)
+	}
+	config.Override(&ConfigOverrides{TCP: &disabled})
+	if config.TCP {
+		t.Fatal("tcp flag should disable the configured tcp")
+	}
+
+	// A missing config file gets the defaults
+	config, err = LoadConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+	if err != nil || config.Address != DefaultAddress || config.Port != DefaultPort {
+		t.Fatalf("missing config file should get the defaults, got: %+v, %v", config, err)
+	}
+	os.WriteFile(configFilePath, []byte("port: [invalid"), 0640)
+	if _, err := LoadConfig(configFilePath); err == nil {
+		t.Fatal("invalid config file should not be loaded")
+	}
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// maxSocketPathLength unix socket paths are limited to 104 bytes on some systems (108 on linux)
+const maxSocketPathLength = 104
+
+// contextKey the type of the request context keys of the server
+type contextKey string
+
+// peerContextKey the request context key of the unix socket peer
+const peerContextKey contextKey = "peer"
+
+// errPeerCredentialsUnsupported the peer credentials of unix sockets are not supported by the os
+var errPeerCredentialsUnsupported = errors.New("peer credentials are not supported")
+
+// peerCredentials the credentials of the process on the other side of the unix socket
+type peerCredentials struct {
+	PID int
+	UID int
+	GID int
+}
+
+// unixPeer the peer of a unix socket connection
+type unixPeer struct {
+	credentials *peerCredentials
+	err         error
+}
+
+// listenUnixSocket listens on the server unix socket
+//
+// Only the owner of the server and the socket group can connect to the socket
+func listenUnixSocket(socketPath string, socketGroupID int) (net.Listener, error) {
+	if len(socketPath) >= maxSocketPathLength {
+		return nil, fmt.Errorf("socket path %s is too long", socketPath)
+	}
+	// A socket that is left by a server that is not running anymore is removed
+	if conn, err := net.Dial("unix", socketPath); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("server is already listening on %s", socketPath)
+	}
+	os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	var socketMode os.FileMode = 0600
+	if socketGroupID >= 0 {
+		if err := os.Chown(socketPath, -1, socketGroupID); err != nil {
+			listener.Close()
+			return nil, err
+		}
+		socketMode = 0660
+	}
+	if err := os.Chmod(socketPath, socketMode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// lookupGroupID gets the id of the group name or id, returns -1 if the group is empty
+func lookupGroupID(group string) (int, error) {
+	if group == "" {
+		return -1, nil
+	}
+	groupInfo, err := user.LookupGroup(group)
+	if err != nil {
+		if groupInfo, err = user.LookupGroupId(group); err != nil {
+			return -1, fmt.Errorf("group %s is not found", group)
+		}
+	}
+	return strconv.Atoi(groupInfo.Gid)
+}
+
+// getConnContext saves the peer of unix socket connections in the connection context
+func getConnContext(ctx context.Context, conn net.Conn) context.Context {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	credentials, err := getPeerCredentials(unixConn)
+	return context.WithValue(ctx, peerContextKey, &unixPeer{credentials: credentials, err: err})
+}
+
+// newPeerCredentialsMiddleware checks that the unix socket requests are sent by the server
+// owner, root or a member of the socket group
+//
+// If the os doesn't support peer credentials, the socket file permissions protect the socket
+func newPeerCredentialsMiddleware(socketGroupID int) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			peer, ok := req.Context().Value(peerContextKey).(*unixPeer)
+			if ok && peer.err != errPeerCredentialsUnsupported && !isPeerAllowed(peer, socketGroupID) {
+				log.Printf("request of uid %d (pid %d) is forbidden\n", peer.getUID(), peer.getPID())
+				SendJSON(res, http.StatusForbidden, &ResponseObject{
+					Success: false,
+					Message: "permission denied",
+					Data:    []byte("null"),
+				})
+				return
+			}
+			next.ServeHTTP(res, req)
+		})
+	}
+}
+
+// isPeerAllowed checks if the peer is the server owner, root or a member of the socket group
+func isPeerAllowed(peer *unixPeer, socketGroupID int) bool {
+	if peer.err != nil || peer.credentials == nil {
+		return false
+	}
+	if peer.credentials.UID == os.Getuid() || peer.credentials.UID == 0 {
+		return true
+	}
+	if socketGroupID < 0 {
+		return false
+	}
+	if peer.credentials.GID == socketGroupID {
+		return true
+	}
+	peerUser, err := user.LookupId(strconv.Itoa(peer.credentials.UID))
+	if err != nil {
+		return false
+	}
+	groupIDs, _ := peerUser.GroupIds()
+	for _, groupID := range groupIDs {
+		if groupID == strconv.Itoa(socketGroupID) {
+			return true
+		}
+	}
+	return false
+}
+
+// getUID gets the uid of the peer, -1 if it is unknown
+func (peer *unixPeer) getUID() int {
+	if peer.credentials == nil {
+		return -1
+	}
+	return peer.credentials.UID
+}
+
+// getPID gets the pid of the peer, -1 if it is unknown
+func (peer *unixPeer) getPID() int {
+	if peer.credentials == nil {
+		return -1
+	}
+	return peer.credentials.PID
+}
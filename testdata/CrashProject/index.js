@@ -0,0 +1,4 @@
+// Testing process is down by exception
+setTimeout(function(){
+    throw 'error'
+}, 100);